@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// aliasKey identifies a title in the curated alias table. Titles are
+// normalized so "Kesariya (From Brahmastra)" and "kesariya" share aliases.
+func aliasKey(title string) string {
+	return normalizeTitle(title)
+}
+
+// answerTitles returns the official title of a round followed by every
+// accepted alias: the ones Gemini suggested and the ones curated by admins.
+func answerTitles(ri Round) []string {
+	titles := []string{ri.Title}
+	seen := map[string]bool{aliasKey(ri.Title): true}
+	for _, a := range append(append([]string(nil), ri.Aliases...), store.Aliases(aliasKey(ri.Title))...) {
+		k := aliasKey(a)
+		if k == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		titles = append(titles, a)
+	}
+	return titles
+}
+
+// isAdmin checks the X-Admin-Token header against ADMIN_TOKEN. Admin
+// endpoints are disabled while ADMIN_TOKEN is unset.
+func isAdmin(r *http.Request) bool {
+	token := os.Getenv("ADMIN_TOKEN")
+	return token != "" && r.Header.Get("X-Admin-Token") == token
+}
+
+// aliasesHandler lists the curated aliases of a title (GET ?title=) and lets
+// admins replace them (POST {title, aliases}). An empty list removes them.
+func aliasesHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method == http.MethodGet {
+		title := r.URL.Query().Get("title")
+		if title == "" {
+			http.Error(w, "missing title", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"title": title, "aliases": store.Aliases(aliasKey(title))})
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAdmin(r) {
+		http.Error(w, "admin token required", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Title   string   `json:"title"`
+		Aliases []string `json:"aliases"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	key := aliasKey(req.Title)
+	if key == "" {
+		http.Error(w, "missing title", http.StatusBadRequest)
+		return
+	}
+	var aliases []string
+	for _, a := range req.Aliases {
+		if a = strings.TrimSpace(a); a != "" {
+			aliases = append(aliases, a)
+		}
+	}
+	if err := store.PutAliases(key, aliases); err != nil {
+		http.Error(w, "store error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"title": req.Title, "aliases": aliases})
+}
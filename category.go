@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// category themes a round's songs by decade, genre, mood or occasion. Theme
+// and Era are worked into the Gemini prompt; a category with an Era replaces
+// the difficulty's era.
+type category struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Kind  string   `json:"kind"` // decade, genre, mood or occasion
+	Theme string   `json:"-"`
+	Era   string   `json:"-"`
+	Langs []string `json:"-"` // languages it makes sense for, empty means all
+}
+
+// indianLangs are the languages festival and wedding categories apply to.
+var indianLangs = []string{"hindi", "tamil", "telugu", "punjabi", "marathi", "bengali", "kannada", "malayalam", "gujarati"}
+
+// categories lists every theme in the order /categories shows them.
+var categories = []category{
+	{ID: "80s", Name: "80s", Kind: "decade", Era: "released in the 1980s"},
+	{ID: "90s", Name: "90s", Kind: "decade", Era: "released in the 1990s"},
+	{ID: "2000s", Name: "2000s", Kind: "decade", Era: "released between 2000 and 2009"},
+	{ID: "2010s", Name: "2010s", Kind: "decade", Era: "released between 2010 and 2019"},
+	{ID: "indie", Name: "Indie", Kind: "genre", Theme: "independent (non-film, non-major-label) indie songs"},
+	{ID: "hiphop", Name: "Hip-hop", Kind: "genre", Theme: "hip-hop and rap songs"},
+	{ID: "devotional", Name: "Devotional", Kind: "genre", Theme: "devotional and spiritual songs (bhajans, hymns, qawwalis and the like)"},
+	{ID: "party", Name: "Party", Kind: "mood", Theme: "party and dance floor songs"},
+	{ID: "romantic", Name: "Romantic", Kind: "mood", Theme: "romantic love songs"},
+	{ID: "sad", Name: "Sad", Kind: "mood", Theme: "sad and heartbreak songs"},
+	{ID: "diwali", Name: "Diwali", Kind: "occasion", Theme: "songs people play during Diwali celebrations", Langs: indianLangs},
+	{ID: "holi", Name: "Holi", Kind: "occasion", Theme: "songs people play during Holi", Langs: []string{"hindi", "punjabi", "bhojpuri", "marathi", "gujarati"}},
+	{ID: "wedding", Name: "Wedding", Kind: "occasion", Theme: "songs played at weddings and sangeet functions", Langs: indianLangs},
+	{ID: "christmas", Name: "Christmas", Kind: "occasion", Theme: "Christmas songs"},
+}
+
+// categoriesFor returns the categories available for lang.
+func categoriesFor(lang string) []category {
+	key := cacheKey(lang)
+	var out []category
+	for _, c := range categories {
+		if len(c.Langs) == 0 || containsString(c.Langs, key) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// lookupCategory finds category id for lang. An empty id is no category.
+func lookupCategory(lang, id string) (category, bool) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	for _, c := range categoriesFor(lang) {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return category{}, false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// categoriesHandler lists the categories of a language: GET /categories?lang=.
+func categoriesHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	lang := r.URL.Query().Get("lang")
+	if lang == "" {
+		http.Error(w, "missing lang parameter", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"lang": lang, "categories": categoriesFor(lang)})
+}
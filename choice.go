@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// Multiple choice rounds show four title/artist options, one of them right.
+// Each round takes a single answer: a wrong option ends it like /reveal.
+// They are worth choiceCreditFactor of a free text round.
+const (
+	choiceOptions      = 4
+	choiceCreditFactor = 0.5
+)
+
+// reasonWrongOption is the /guess reason for picking a wrong option.
+const reasonWrongOption = "wrong_option"
+
+// fetchSimilarSongs asks for songs that could be confused with the answer.
+// It is replaced in tests that check where distractors come from.
+var fetchSimilarSongs = geminiSimilarSongs
+
+// pickOptions returns the answer plus three distractors in random order.
+// Distractors come, in order of preference, from the query's cached Gemini
+// list or playlist, a Gemini request for similar songs, earlier rounds in the
+// same language and finally a plain video search.
+func pickOptions(ctx context.Context, q songQuery, answer Song) ([]Song, error) {
+	lang := q.Lang
+	seen := map[string]bool{aliasKey(answer.Title): true}
+	for _, a := range answer.Aliases {
+		seen[aliasKey(a)] = true
+	}
+	var distractors []Song
+	add := func(songs []Song) {
+		rand.Shuffle(len(songs), func(i, j int) { songs[i], songs[j] = songs[j], songs[i] })
+		for _, s := range songs {
+			if len(distractors) == choiceOptions-1 {
+				return
+			}
+			k := aliasKey(s.Title)
+			if k == "" || seen[k] || matcher.Match(s.Title, answer.Title) {
+				continue
+			}
+			seen[k] = true
+			distractors = append(distractors, Song{Title: s.Title, Artist: s.Artist})
+		}
+	}
+
+	if q.Playlist != "" {
+		if p, ok := store.GetPlaylist(q.Playlist); ok {
+			add(p.Songs)
+		}
+	} else if songs, ok := store.SongList(q.key()); ok {
+		add(songs)
+	}
+	if len(distractors) < choiceOptions-1 && os.Getenv("GEMINI_API_KEY") != "" {
+		songs, err := fetchSimilarSongs(lang, answer)
+		if err != nil {
+			log.Printf("choice: similar songs for %q: %v", answer.Title, err)
+		}
+		add(songs)
+	}
+	if len(distractors) < choiceOptions-1 {
+		var past []Song
+		for _, ri := range store.Rounds() {
+			if strings.EqualFold(ri.Lang, lang) && ri.Title != "" {
+				past = append(past, Song{Title: ri.Title, Artist: ri.Artist})
+			}
+		}
+		add(past)
+	}
+	if len(distractors) < choiceOptions-1 {
+		if results, err := media.Search(ctx, searchQueryFor(q), 10); err == nil {
+			var found []Song
+			for _, v := range results {
+				if !isBanned(v.Title, bannedKeywords) {
+					found = append(found, Song{Title: v.Title, Artist: v.Uploader})
+				}
+			}
+			add(found)
+		}
+	}
+	if len(distractors) < choiceOptions-1 {
+		return nil, fmt.Errorf("only %d distractors found for %s", len(distractors), lang)
+	}
+
+	opts := append(distractors, Song{Title: answer.Title, Artist: answer.Artist})
+	rand.Shuffle(len(opts), func(i, j int) { opts[i], opts[j] = opts[j], opts[i] })
+	return opts, nil
+}
+
+// answerIndex is the position of the right option, -1 if there is none.
+func answerIndex(ri Round) int {
+	for i, o := range ri.Options {
+		if o.Title == ri.Title && o.Artist == ri.Artist {
+			return i
+		}
+	}
+	return -1
+}
+
+// geminiSimilarSongs asks Gemini for songs in lang that sound or feel like
+// answer, by comparable artists, to use as distractors.
+func geminiSimilarSongs(lang string, answer Song) ([]Song, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey: os.Getenv("GEMINI_API_KEY"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	prompt := fmt.Sprintf(`List 6 real, well-known %s songs that a listener could confuse with "%s" by %s:
+similar sounding titles, the same era and genre, or comparable artists. Do not include the song itself.
+Return ONLY a valid JSON array like:
+[{"title":"Song Title","artist":"Artist Name"}]`, lang, answer.Title, answer.Artist)
+
+	resp, err := client.Models.GenerateContent(ctx, "gemini-2.5-flash", genai.Text(prompt), nil)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSpace(resp.Text())
+	if i := strings.Index(text, "["); i >= 0 {
+		if j := strings.LastIndex(text, "]"); j > i {
+			text = text[i : j+1]
+		}
+	}
+	var songs []Song
+	if err := json.Unmarshal([]byte(text), &songs); err != nil {
+		return nil, fmt.Errorf("could not parse similar songs: %v", err)
+	}
+	return songs, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPickOptionsFromSimilarSongs(t *testing.T) {
+	newTestServer(t, kesariya)
+	t.Setenv("GEMINI_API_KEY", "test")
+	old := fetchSimilarSongs
+	t.Cleanup(func() { fetchSimilarSongs = old })
+	fetchSimilarSongs = func(lang string, answer Song) ([]Song, error) {
+		return []Song{
+			{Title: "KESARIYA", Artist: "Someone Else"},
+			{Title: "Tum Hi Ho", Artist: "Arijit Singh"},
+			{Title: "Channa Mereya", Artist: "Arijit Singh"},
+			{Title: "Raataan Lambiyan", Artist: "Jubin Nautiyal"},
+		}, nil
+	}
+
+	answer := Song{Title: "Kesariya", Artist: "Arijit Singh"}
+	opts, err := pickOptions(context.Background(), songQuery{Lang: "hindi"}, answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts) != choiceOptions {
+		t.Fatalf("got %d options, want %d: %v", len(opts), choiceOptions, opts)
+	}
+	want := map[string]bool{"Kesariya": true, "Tum Hi Ho": true, "Channa Mereya": true, "Raataan Lambiyan": true}
+	for _, o := range opts {
+		if !want[o.Title] {
+			t.Errorf("unexpected option %+v", o)
+		}
+		delete(want, o.Title)
+	}
+	if len(want) != 0 {
+		t.Errorf("missing options %v", want)
+	}
+	if answerIndex(Round{Title: answer.Title, Artist: answer.Artist, Options: opts}) < 0 {
+		t.Errorf("answer not among options %v", opts)
+	}
+}
+
+func TestPickOptionsPrefersSongList(t *testing.T) {
+	newTestServer(t, kesariya)
+	t.Setenv("GEMINI_API_KEY", "test")
+	old := fetchSimilarSongs
+	t.Cleanup(func() { fetchSimilarSongs = old })
+	fetchSimilarSongs = func(lang string, answer Song) ([]Song, error) {
+		t.Error("asked for similar songs although the song list had enough")
+		return nil, nil
+	}
+	q := songQuery{Lang: "hindi"}
+	store.PutSongList(q.key(), []Song{
+		{Title: "Kesariya", Artist: "Arijit Singh"},
+		{Title: "Tum Hi Ho", Artist: "Arijit Singh"},
+		{Title: "Channa Mereya", Artist: "Arijit Singh"},
+		{Title: "Raataan Lambiyan", Artist: "Jubin Nautiyal"},
+	})
+
+	opts, err := pickOptions(context.Background(), q, Song{Title: "Kesariya", Artist: "Arijit Singh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts) != choiceOptions {
+		t.Fatalf("got %d options, want %d: %v", len(opts), choiceOptions, opts)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import "strings"
+
+// Difficulty levels shape which songs Gemini suggests, the default clip
+// length and segment, and how forgiving guess matching is.
+const (
+	difficultyEasy   = "easy"
+	difficultyMedium = "medium"
+	difficultyHard   = "hard"
+	difficultyExpert = "expert"
+)
+
+type difficultyProfile struct {
+	ClipLength int    // default when /start has no clipLength
+	Segment    string // default when /start has no segment
+	Strictness Strictness
+	// Songs and Era complete the Gemini prompt: "Provide ... <Songs> in
+	// the X language <Era>".
+	Songs string
+	Era   string
+}
+
+// difficulties maps each level to its profile. Medium is the default and
+// matches the game before difficulty existed; it matches guesses with the
+// server wide MATCH_STRICTNESS.
+var difficulties = map[string]difficultyProfile{
+	difficultyEasy: {
+		ClipLength: 30, Segment: segmentChorus, Strictness: StrictnessLenient,
+		Songs: "chart-topping mega-hits that almost everyone has heard",
+		Era:   "from the last 2 years",
+	},
+	difficultyMedium: {
+		ClipLength: 30, Segment: segmentIntro, Strictness: StrictnessNormal,
+		Songs: "popular songs",
+		Era:   "from the last 2 years",
+	},
+	difficultyHard: {
+		ClipLength: 15, Segment: segmentRandom, Strictness: StrictnessNormal,
+		Songs: "well-known songs that are not the biggest hits",
+		Era:   "from any year of the last 20 years",
+	},
+	difficultyExpert: {
+		ClipLength: 8, Segment: segmentRandom, Strictness: StrictnessStrict,
+		Songs: "deep cuts and lesser-known album tracks by established artists",
+		Era:   "from any era of the language's catalog",
+	},
+}
+
+// parseDifficulty normalizes a difficulty name; empty means medium.
+func parseDifficulty(s string) (string, bool) {
+	d := strings.ToLower(strings.TrimSpace(s))
+	if d == "" {
+		return difficultyMedium, true
+	}
+	_, ok := difficulties[d]
+	return d, ok
+}
+
+func difficultyFor(name string) difficultyProfile {
+	if p, ok := difficulties[name]; ok {
+		return p
+	}
+	return difficulties[difficultyMedium]
+}
+
+// matcherFor returns the matcher for a round's difficulty and language.
+func matcherFor(ri Round) Matcher {
+	m := matcher
+	if ri.Difficulty != "" && ri.Difficulty != difficultyMedium {
+		m = Matcher{Strictness: difficultyFor(ri.Difficulty).Strictness}
+	}
+	m.Indic = isIndicLang(ri.Lang)
+	return m
+}
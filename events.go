@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Stages a round goes through before its clip can be played.
+const (
+	stageResolving   = "resolving"   // picking a song and its clip offset
+	stageDownloading = "downloading" // fetching the audio
+	stageTrimming    = "trimming"    // cutting the clip
+	stageReady       = "ready"
+	stageFailed      = "failed"
+)
+
+// roundEvent is what /events streams for each state change of a round.
+type roundEvent struct {
+	ID       string    `json:"id"`
+	Stage    string    `json:"stage"`
+	Ready    bool      `json:"ready"`
+	Progress *Progress `json:"progress,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Expired  bool      `json:"expired,omitempty"`
+}
+
+func eventFor(ri Round) roundEvent {
+	return roundEvent{ID: ri.ID, Stage: ri.Stage, Ready: ri.Ready, Progress: ri.Progress, Error: ri.Error, Expired: ri.Expired}
+}
+
+func sameEvent(a, b roundEvent) bool {
+	ap, bp := a.Progress, b.Progress
+	a.Progress, b.Progress = nil, nil
+	return a == b && (ap == bp || ap != nil && bp != nil && *ap == *bp)
+}
+
+// final reports whether no more events will follow.
+func (e roundEvent) final() bool {
+	return e.Ready || e.Stage == stageFailed || e.Expired
+}
+
+// eventBroker fans round state changes out to subscribers. Slow subscribers
+// miss intermediate events, never the latest one: each channel holds a single
+// pending event that is replaced by newer ones.
+type eventBroker struct {
+	mu   sync.Mutex
+	subs map[string]map[chan roundEvent]bool
+}
+
+var roundEvents = &eventBroker{subs: map[string]map[chan roundEvent]bool{}}
+
+func (b *eventBroker) subscribe(id string) chan roundEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan roundEvent, 1)
+	if b.subs[id] == nil {
+		b.subs[id] = map[chan roundEvent]bool{}
+	}
+	b.subs[id][ch] = true
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(id string, ch chan roundEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subs[id], ch)
+	if len(b.subs[id]) == 0 {
+		delete(b.subs, id)
+	}
+}
+
+// publish sends the current state of ri to its subscribers.
+func (b *eventBroker) publish(ri Round) {
+	ev := eventFor(ri)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[ri.ID] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- ev
+	}
+}
+
+// updateRoundAndNotify is store.UpdateRound followed by publishing the
+// new state to /events subscribers.
+func updateRoundAndNotify(id string, fn func(*Round)) (Round, error) {
+	ri, err := store.UpdateRound(id, fn)
+	if err == nil {
+		roundEvents.publish(ri)
+	}
+	return ri, err
+}
+
+// Progress updates are throttled before they reach the store, which
+// rewrites its file on every change.
+const (
+	progressMinStep     = 5 // percent
+	progressMinInterval = time.Second
+)
+
+// roundProgressRecorder returns a ProgressFunc that records progress on the
+// round with the given id. Stage changes and completion are always recorded,
+// other updates only every progressMinStep percent or progressMinInterval.
+func roundProgressRecorder(id string) ProgressFunc {
+	var last Progress
+	var lastAt time.Time
+	return func(p Progress) {
+		if p.Stage == last.Stage && p.Percent < 100 &&
+			p.Percent-last.Percent < progressMinStep && time.Since(lastAt) < progressMinInterval {
+			return
+		}
+		last, lastAt = p, time.Now()
+		if _, err := updateRoundAndNotify(id, func(rr *Round) {
+			rr.Stage = p.Stage
+			rr.Progress = &p
+		}); err != nil {
+			log.Printf("update round %s: %v", id, err)
+		}
+	}
+}
+
+// waitRoundReady blocks until the round's clip is ready, it failed, or
+// timeout passes.
+func waitRoundReady(id string, timeout time.Duration) (Round, error) {
+	ch := roundEvents.subscribe(id)
+	defer roundEvents.unsubscribe(id, ch)
+	deadline := time.After(timeout)
+	for {
+		ri, ok := store.GetRound(id)
+		switch {
+		case !ok:
+			return Round{}, errRoundNotFound
+		case ri.Ready:
+			return ri, nil
+		case ri.Error != "":
+			return Round{}, errors.New(ri.Error)
+		case ri.Expired:
+			return Round{}, errors.New("round expired")
+		}
+		select {
+		case <-ch:
+		case <-deadline:
+			return Round{}, errors.New("timed out waiting for clip")
+		}
+	}
+}
+
+// eventsHandler streams a round's stage changes as Server-Sent Events until
+// it is ready, failed or expired. The current state is sent first, so a
+// client that connects late still learns where the round is.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	// subscribe before reading the round so no transition is missed
+	ch := roundEvents.subscribe(id)
+	defer roundEvents.unsubscribe(id, ch)
+	ri, ok := store.GetRound(id)
+	if !ok {
+		http.Error(w, "round not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	send := func(ev roundEvent) {
+		b, _ := json.Marshal(ev)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Stage, b)
+		flusher.Flush()
+	}
+	last := eventFor(ri)
+	send(last)
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for !last.final() {
+		select {
+		case ev := <-ch:
+			if !sameEvent(ev, last) {
+				send(ev)
+				last = ev
+			}
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// fakeMedia is an in-memory MediaBackend that never touches the network.
+// Searches are answered from a small built-in catalog and clips are
+// generated sine tones (one pitch per video) written as WAV files.
+type fakeMedia struct {
+	mu      sync.Mutex
+	catalog []VideoInfo
+}
+
+// fakeCatalog is the default set of videos served by fakeMedia.
+var fakeCatalog = []Song{
+	{Title: "Kesariya", Artist: "Arijit Singh"},
+	{Title: "Naatu Naatu", Artist: "Rahul Sipligunj"},
+	{Title: "Arabic Kuthu", Artist: "Anirudh Ravichander"},
+	{Title: "Flowers", Artist: "Miley Cyrus"},
+	{Title: "Anti-Hero", Artist: "Taylor Swift"},
+	{Title: "Calm Down", Artist: "Rema"},
+	{Title: "Chaleya", Artist: "Arijit Singh"},
+	{Title: "Hukum", Artist: "Anirudh Ravichander"},
+}
+
+func newFakeMedia() *fakeMedia {
+	f := &fakeMedia{}
+	for i, s := range fakeCatalog {
+		f.Add(VideoInfo{ID: fmt.Sprintf("fake%07d", i+1), Title: s.Title, Uploader: s.Artist, Duration: 180 + 15*i})
+	}
+	return f
+}
+
+// Add registers a video so Search and Probe can find it.
+func (f *fakeMedia) Add(v VideoInfo) {
+	if v.URL == "" {
+		v.URL = "https://www.youtube.com/watch?v=" + v.ID
+	}
+	f.mu.Lock()
+	f.catalog = append(f.catalog, v)
+	f.mu.Unlock()
+}
+
+func (f *fakeMedia) Search(ctx context.Context, query string, n int) ([]VideoInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q := strings.ToLower(query)
+	// videos whose title appears in the query come first, the rest follow
+	var hits, rest []VideoInfo
+	for _, v := range f.catalog {
+		if strings.Contains(q, strings.ToLower(v.Title)) {
+			hits = append(hits, v)
+		} else {
+			rest = append(rest, v)
+		}
+	}
+	results := append(hits, rest...)
+	if n > 0 && len(results) > n {
+		results = results[:n]
+	}
+	return results, nil
+}
+
+func (f *fakeMedia) Probe(ctx context.Context, url string) (VideoInfo, error) {
+	id := extractYouTubeID(url)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, v := range f.catalog {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+	return VideoInfo{ID: id, URL: url, Title: id, Duration: 200}, nil
+}
+
+// Playlist lists the whole catalog, whatever the URL.
+func (f *fakeMedia) Playlist(ctx context.Context, url string) (PlaylistInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return PlaylistInfo{Title: "Fake playlist", Entries: append([]VideoInfo(nil), f.catalog...)}, nil
+}
+
+func (f *fakeMedia) Download(ctx context.Context, url, dir string, progress ProgressFunc) (string, error) {
+	id := extractYouTubeID(url)
+	if id == "" {
+		return "", fmt.Errorf("no video id in %q", url)
+	}
+	size := int64(len(id))
+	progress(Progress{Percent: 0, TotalBytes: size})
+	path := filepath.Join(dir, id+".fake")
+	if err := os.WriteFile(path, []byte(id), 0o644); err != nil {
+		return "", err
+	}
+	progress(Progress{Percent: 100, Bytes: size, TotalBytes: size})
+	return path, nil
+}
+
+func (f *fakeMedia) Trim(ctx context.Context, in, dir string, start, length int, progress ProgressFunc) (string, error) {
+	id, err := os.ReadFile(in)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New32a()
+	h.Write(id)
+	freq := 220 + float64(h.Sum32()%440)
+	out := filepath.Join(dir, "clip.wav")
+	wav := toneWAV(freq, length)
+	if err := os.WriteFile(out, wav, 0o644); err != nil {
+		return "", err
+	}
+	progress(Progress{Percent: 100, Bytes: int64(len(wav))})
+	return out, nil
+}
+
+// toneWAV returns a mono 16-bit 8kHz WAV file containing a sine wave.
+func toneWAV(freq float64, seconds int) []byte {
+	const rate = 8000
+	samples := rate * seconds
+	buf := make([]byte, 44+2*samples)
+	copy(buf[0:], "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:], uint32(36+2*samples))
+	copy(buf[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(buf[16:], 16)
+	binary.LittleEndian.PutUint16(buf[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(buf[22:], 1) // mono
+	binary.LittleEndian.PutUint32(buf[24:], rate)
+	binary.LittleEndian.PutUint32(buf[28:], rate*2)
+	binary.LittleEndian.PutUint16(buf[32:], 2)
+	binary.LittleEndian.PutUint16(buf[34:], 16)
+	copy(buf[36:], "data")
+	binary.LittleEndian.PutUint32(buf[40:], uint32(2*samples))
+	for i := 0; i < samples; i++ {
+		v := int16(8000 * math.Sin(2*math.Pi*freq*float64(i)/rate))
+		binary.LittleEndian.PutUint16(buf[44+2*i:], uint16(v))
+	}
+	return buf
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/genai"
+)
+
+// Hints escalate from vague to nearly giving the song away. Taking a level
+// also unlocks every level below it, and each newly unlocked level adds its
+// cost to Round.HintCost, which scoreRound subtracts.
+const (
+	hintWordCount = iota + 1
+	hintFirstLetters
+	hintArtistInitials
+	hintYear
+	hintFilm
+	maxHintLevel = hintFilm
+)
+
+var hintKinds = map[int]string{
+	hintWordCount:      "word_count",
+	hintFirstLetters:   "first_letters",
+	hintArtistInitials: "artist_initials",
+	hintYear:           "year",
+	hintFilm:           "film",
+}
+
+var hintCosts = map[int]int{
+	hintWordCount:      50,
+	hintFirstLetters:   100,
+	hintArtistInitials: 150,
+	hintYear:           200,
+	hintFilm:           250,
+}
+
+type hint struct {
+	Level     int    `json:"level"`
+	Kind      string `json:"kind"`
+	Text      string `json:"text"`
+	Cost      int    `json:"cost"`
+	Available bool   `json:"available"`
+}
+
+// fetchSongDetails looks up the release year and film of a song for the
+// richer hints. Tests stub it instead of calling Gemini.
+var fetchSongDetails = geminiSongDetails
+
+// hintTitle is the title without "feat." credits and bracketed qualifiers.
+func hintTitle(title string) string {
+	t := featRe.ReplaceAllString(title, "")
+	if s := strings.TrimSpace(bracketsRe.ReplaceAllString(t, " ")); s != "" {
+		t = s
+	}
+	return strings.Join(strings.Fields(t), " ")
+}
+
+// firstLetters keeps the first letter of every word and blanks the rest:
+// "Naatu Naatu" -> "N____ N____".
+func firstLetters(title string) string {
+	var b strings.Builder
+	first := true
+	for _, r := range title {
+		switch {
+		case unicode.IsSpace(r):
+			b.WriteRune(' ')
+			first = true
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			if first {
+				b.WriteRune(r)
+			} else {
+				b.WriteRune('_')
+			}
+			first = false
+		case unicode.IsMark(r):
+			// vowel signs belong to the letter before them
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// initials turns "Arijit Singh & Shreya Ghoshal" into "A. S. & S. G.".
+func initials(artist string) string {
+	var parts []string
+	for _, w := range strings.Fields(artist) {
+		r := []rune(w)
+		if unicode.IsLetter(r[0]) {
+			parts = append(parts, string(r[0])+".")
+		} else {
+			parts = append(parts, w)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// hintFor builds the hint of one level. Hints that rely on metadata the
+// round does not have are unavailable and cost nothing.
+func hintFor(ri Round, level int) hint {
+	h := hint{Level: level, Kind: hintKinds[level], Cost: hintCosts[level], Available: true}
+	title := hintTitle(ri.Title)
+	switch level {
+	case hintWordCount:
+		n := len(strings.Fields(title))
+		h.Text = fmt.Sprintf("%d words", n)
+		if n == 1 {
+			h.Text = "1 word"
+		}
+	case hintFirstLetters:
+		h.Text = firstLetters(title)
+	case hintArtistInitials:
+		h.Text = initials(ri.Artist)
+	case hintYear:
+		if ri.Year > 0 {
+			h.Text = strconv.Itoa(ri.Year)
+		}
+	case hintFilm:
+		h.Text = ri.Film
+		for _, t := range answerTitles(ri) {
+			if ri.Film != "" && matcher.Match(ri.Film, t) {
+				// title tracks: naming the film would name the song
+				h.Text = "the film or album has the same name as the song"
+				break
+			}
+		}
+	}
+	if h.Text == "" {
+		h.Text, h.Cost, h.Available = "unknown", 0, false
+	}
+	return h
+}
+
+// hintHandler serves GET /hint?id=&level=. Without level the next locked
+// hint is unlocked. Hints are free once the title is guessed or revealed.
+func hintHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	ri, ok := store.GetRound(r.URL.Query().Get("id"))
+	if !ok {
+		http.Error(w, "round not found", http.StatusNotFound)
+		return
+	}
+	if roomRoundInPlay(ri) {
+		http.Error(w, "room rounds have no hints while they are played", http.StatusConflict)
+		return
+	}
+	if ri.Title == "" {
+		http.Error(w, "round has no song yet, follow /events", http.StatusConflict)
+		return
+	}
+	if !ownedBy(r, ri.SessionID) {
+		http.Error(w, "round belongs to another session", http.StatusForbidden)
+		return
+	}
+	level := min(ri.HintLevel+1, maxHintLevel)
+	if l := r.URL.Query().Get("level"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 || parsed > maxHintLevel {
+			http.Error(w, fmt.Sprintf("level must be between 1 and %d", maxHintLevel), http.StatusBadRequest)
+			return
+		}
+		level = parsed
+	}
+
+	if level >= hintYear && !ri.DetailsLookup && (ri.Year == 0 || ri.Film == "") {
+		year, film, err := fetchSongDetails(ri.Title, ri.Artist, ri.Lang)
+		if err != nil {
+			log.Printf("hint: details for %q: %v", ri.Title, err)
+		}
+		if updated, uerr := store.UpdateRound(ri.ID, func(rr *Round) {
+			if err == nil {
+				rr.DetailsLookup = true
+			}
+			if rr.Year == 0 {
+				rr.Year = year
+			}
+			if rr.Film == "" {
+				rr.Film = film
+			}
+		}); uerr == nil {
+			ri = updated
+		}
+	}
+
+	hints := make([]hint, 0, level)
+	for l := 1; l <= level; l++ {
+		hints = append(hints, hintFor(ri, l))
+	}
+	charged := 0
+	ri, err := store.UpdateRound(ri.ID, func(rr *Round) {
+		if level <= rr.HintLevel {
+			return
+		}
+		if !rr.Solved && !rr.Revealed {
+			for _, h := range hints[rr.HintLevel:] {
+				charged += h.Cost
+			}
+			rr.HintCost += charged
+		}
+		rr.HintLevel = level
+	})
+	if err != nil {
+		log.Printf("update round %s: %v", ri.ID, err)
+	}
+	writeJSON(w, map[string]interface{}{"id": ri.ID, "level": level, "max_level": maxHintLevel, "hints": hints, "charged": charged, "hint_cost": ri.HintCost})
+}
+
+// geminiSongDetails asks Gemini for the release year and the film (or
+// album) of a song.
+func geminiSongDetails(title, artist, lang string) (int, string, error) {
+	if os.Getenv("GEMINI_API_KEY") == "" {
+		return 0, "", errSourceUnavailable
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey: os.Getenv("GEMINI_API_KEY"),
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	prompt := fmt.Sprintf(`For the %s song "%s" by %s, return ONLY a JSON object like:
+{"year":2023,"film":"Film or Album Name"}
+Use the original release year. "film" is the film the song is from, or the album if it
+is not a film song; use an empty string if unknown.`, lang, title, artist)
+
+	resp, err := client.Models.GenerateContent(ctx, "gemini-2.5-flash", genai.Text(prompt), nil)
+	if err != nil {
+		return 0, "", err
+	}
+	text := strings.TrimSpace(resp.Text())
+	if i := strings.Index(text, "{"); i >= 0 {
+		if j := strings.LastIndex(text, "}"); j > i {
+			text = text[i : j+1]
+		}
+	}
+	var details struct {
+		Year json.Number `json:"year"`
+		Film string      `json:"film"`
+	}
+	if err := json.Unmarshal([]byte(text), &details); err != nil {
+		return 0, "", fmt.Errorf("could not parse song details: %v", err)
+	}
+	year, _ := strconv.Atoi(details.Year.String())
+	return year, strings.TrimSpace(details.Film), nil
+}
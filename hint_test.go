@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHintDetailsAndFilm(t *testing.T) {
+	tests := []struct {
+		name, film string
+		want       string
+	}{
+		{"film", "Brahmastra", "Brahmastra"},
+		{"title track", "Kesariya", "the film or album has the same name as the song"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, kesariya)
+			old := fetchSongDetails
+			t.Cleanup(func() { fetchSongDetails = old })
+			calls := 0
+			fetchSongDetails = func(title, artist, lang string) (int, string, error) {
+				calls++
+				return 2022, tt.film, nil
+			}
+			store.PutRound(Round{ID: "r1", Lang: "hindi", Title: "Kesariya", Artist: "Arijit Singh", Ready: true})
+
+			var resp struct {
+				Hints    []hint `json:"hints"`
+				Charged  int    `json:"charged"`
+				HintCost int    `json:"hint_cost"`
+			}
+			getJSON(t, srv.URL+"/hint?id=r1&level=5", &resp)
+			if len(resp.Hints) != maxHintLevel {
+				t.Fatalf("got %d hints, want %d", len(resp.Hints), maxHintLevel)
+			}
+			if got := resp.Hints[hintYear-1].Text; got != "2022" {
+				t.Errorf("year hint = %q, want 2022", got)
+			}
+			if got := resp.Hints[hintFilm-1].Text; got != tt.want {
+				t.Errorf("film hint = %q, want %q", got, tt.want)
+			}
+			if resp.Charged != 750 || resp.HintCost != 750 {
+				t.Errorf("charged %d, hint cost %d, want 750", resp.Charged, resp.HintCost)
+			}
+
+			// the details are looked up once and hints already taken are free
+			getJSON(t, srv.URL+"/hint?id=r1&level=5", &resp)
+			if calls != 1 || resp.Charged != 0 {
+				t.Errorf("second request: %d lookups, charged %d", calls, resp.Charged)
+			}
+		})
+	}
+}
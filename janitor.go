@@ -0,0 +1,221 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// janitorConfig controls how long clips are kept and how much disk they may use.
+type janitorConfig struct {
+	TTL        time.Duration // rounds older than this are expired
+	QuotaBytes int64         // total clip storage allowed, 0 means unlimited
+	Interval   time.Duration // time between sweeps
+	History    time.Duration // expired rounds older than this are deleted, 0 keeps them
+}
+
+// sweepReport summarizes a single janitor run.
+type sweepReport struct {
+	At             time.Time `json:"at"`
+	ExpiredRounds  int       `json:"expired_rounds"`
+	OverQuota      int       `json:"over_quota_rounds"`
+	OrphanDirs     int       `json:"orphan_dirs"`
+	PrunedRounds   int       `json:"pruned_rounds"`
+	ReclaimedBytes int64     `json:"reclaimed_bytes"`
+	ClipBytes      int64     `json:"clip_bytes"`
+	QuotaBytes     int64     `json:"quota_bytes"`
+}
+
+var (
+	janitorMu   sync.Mutex
+	janitorCfg  = janitorConfig{TTL: 2 * time.Hour, QuotaBytes: 1 << 30, Interval: 5 * time.Minute}
+	lastSweep   sweepReport
+	clipDirGlob = "songclip*"
+)
+
+// janitorConfigFromEnv reads ROUND_TTL, CLIP_QUOTA_MB, JANITOR_INTERVAL and
+// ROUND_HISTORY_DAYS, keeping the defaults for unset or invalid values.
+func janitorConfigFromEnv() janitorConfig {
+	cfg := janitorCfg
+	if v, err := time.ParseDuration(os.Getenv("ROUND_TTL")); err == nil && v > 0 {
+		cfg.TTL = v
+	}
+	if v, err := strconv.ParseInt(os.Getenv("CLIP_QUOTA_MB"), 10, 64); err == nil && v >= 0 {
+		cfg.QuotaBytes = v << 20
+	}
+	if v, err := time.ParseDuration(os.Getenv("JANITOR_INTERVAL")); err == nil && v > 0 {
+		cfg.Interval = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("ROUND_HISTORY_DAYS")); err == nil && v >= 0 {
+		cfg.History = time.Duration(v) * 24 * time.Hour
+	}
+	return cfg
+}
+
+// runJanitor sweeps once immediately and then every cfg.Interval.
+func runJanitor(cfg janitorConfig) {
+	janitorMu.Lock()
+	janitorCfg = cfg
+	janitorMu.Unlock()
+	for {
+		sweep(time.Now())
+		time.Sleep(cfg.Interval)
+	}
+}
+
+// sweep expires rounds past their TTL, then expires the oldest remaining
+// rounds until clip storage fits the quota, removes clip directories no
+// round refers to and finally drops rounds older than the history window
+// from the store. It returns what was reclaimed.
+func sweep(now time.Time) sweepReport {
+	janitorMu.Lock()
+	defer janitorMu.Unlock()
+	cfg := janitorCfg
+	rep := sweepReport{At: now, QuotaBytes: cfg.QuotaBytes}
+
+	type live struct {
+		id   string
+		size int64
+	}
+	var alive []live
+	known := map[string]bool{}
+	for _, ri := range store.Rounds() {
+		if ri.ClipDir != "" {
+			known[filepath.Clean(ri.ClipDir)] = true
+		}
+		if ri.Expired {
+			continue
+		}
+		if now.Sub(ri.CreatedAt) > cfg.TTL {
+			rep.ReclaimedBytes += expireRound(ri.ID)
+			rep.ExpiredRounds++
+			continue
+		}
+		if ri.ClipDir != "" {
+			alive = append(alive, live{id: ri.ID, size: dirSize(ri.ClipDir)})
+		}
+	}
+
+	// prefetched rounds are not in the store yet but count toward the
+	// quota, and go first since nobody is playing them
+	rep.ReclaimedBytes += prefetch.Sweep(now, cfg.TTL)
+	rep.ClipBytes = prefetch.Bytes()
+	for _, l := range alive {
+		rep.ClipBytes += l.size
+	}
+	if cfg.QuotaBytes > 0 && rep.ClipBytes > cfg.QuotaBytes {
+		freed := prefetch.Trim(rep.ClipBytes - cfg.QuotaBytes)
+		rep.ReclaimedBytes += freed
+		rep.ClipBytes -= freed
+	}
+
+	// rounds come back oldest first, so trim from the front
+	for i := 0; cfg.QuotaBytes > 0 && rep.ClipBytes > cfg.QuotaBytes && i < len(alive); i++ {
+		rep.ReclaimedBytes += expireRound(alive[i].id)
+		rep.ClipBytes -= alive[i].size
+		rep.OverQuota++
+	}
+
+	for _, d := range prefetch.Dirs() {
+		known[filepath.Clean(d)] = true
+	}
+
+	// directories left behind by failed downloads or rounds lost before the store existed
+	dirs, _ := filepath.Glob(filepath.Join(os.TempDir(), clipDirGlob))
+	sort.Strings(dirs)
+	for _, d := range dirs {
+		if known[filepath.Clean(d)] {
+			continue
+		}
+		fi, err := os.Stat(d)
+		if err != nil || !fi.IsDir() || now.Sub(fi.ModTime()) <= cfg.TTL {
+			continue
+		}
+		size := dirSize(d)
+		if err := os.RemoveAll(d); err != nil {
+			log.Printf("janitor: remove %s: %v", d, err)
+			continue
+		}
+		rep.ReclaimedBytes += size
+		rep.OrphanDirs++
+	}
+
+	if cfg.History > 0 {
+		rep.PrunedRounds = store.PruneRounds(now.Add(-cfg.History))
+	}
+
+	if rep.ExpiredRounds > 0 || rep.OverQuota > 0 || rep.OrphanDirs > 0 || rep.PrunedRounds > 0 {
+		log.Printf("janitor: expired %d rounds (%d over quota), removed %d orphan dirs, pruned %d old rounds, reclaimed %d bytes, clips now use %d bytes",
+			rep.ExpiredRounds, rep.OverQuota, rep.OrphanDirs, rep.PrunedRounds, rep.ReclaimedBytes, rep.ClipBytes)
+	}
+	lastSweep = rep
+	return rep
+}
+
+// expireRound deletes the clip directory of a round and marks it expired.
+// The round metadata is kept for history. It returns the bytes freed.
+func expireRound(id string) int64 {
+	var dir string
+	_, err := updateRoundAndNotify(id, func(rr *Round) {
+		dir = rr.ClipDir
+		rr.Expired = true
+		rr.Ready = false
+		rr.ClipPath = ""
+	})
+	if err != nil {
+		log.Printf("janitor: expire round %s: %v", id, err)
+		return 0
+	}
+	return removeClipDir(dir)
+}
+
+// removeClipDir deletes a songclip directory and returns its size. Paths
+// that are not clip directories are left alone.
+func removeClipDir(dir string) int64 {
+	if dir == "" || !strings.HasPrefix(filepath.Base(dir), "songclip") {
+		return 0
+	}
+	size := dirSize(dir)
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("janitor: remove %s: %v", dir, err)
+		return 0
+	}
+	return size
+}
+
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// janitorHandler returns the last sweep report. POST runs a sweep right away.
+func janitorHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method == http.MethodPost {
+		writeJSON(w, sweep(time.Now()))
+		return
+	}
+	janitorMu.Lock()
+	rep := lastSweep
+	janitorMu.Unlock()
+	writeJSON(w, rep)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Leaderboards are computed from the rounds in the store, so they survive
+// restarts and need no bookkeeping of their own. A player is a session;
+// rounds played without one are not ranked.
+
+// Leaderboard periods. Daily and weekly boards reset at midnight UTC and on
+// Monday respectively.
+const (
+	periodAll    = "all"
+	periodWeekly = "weekly"
+	periodDaily  = "daily"
+)
+
+// leaderboardFilter selects the rounds that count towards a board.
+type leaderboardFilter struct {
+	Period     string
+	Lang       string // empty means every language
+	ClipLength int    // 0 means every clip length
+}
+
+type leaderboardEntry struct {
+	Rank   int    `json:"rank"`
+	Player string `json:"player"`
+	Name   string `json:"name,omitempty"`
+	Score  int    `json:"score"`
+	Rounds int    `json:"rounds"`
+	Solved int    `json:"solved"`
+
+	session string
+}
+
+// periodStart returns when the current period began, zero for all-time.
+func periodStart(period string, now time.Time) time.Time {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case periodDaily:
+		return day
+	case periodWeekly:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	}
+	return time.Time{}
+}
+
+// publicPlayerID identifies a session on the boards without revealing its
+// token, which would let anyone play as that session.
+func publicPlayerID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:5])
+}
+
+// leaderboard ranks every session with rounds matching f, best first.
+// Equal scores share a rank.
+func leaderboard(f leaderboardFilter, now time.Time) []leaderboardEntry {
+	since := periodStart(f.Period, now)
+	bySession := map[string]*leaderboardEntry{}
+	for _, ri := range store.Rounds() {
+		if ri.SessionID == "" || ri.CreatedAt.Before(since) {
+			continue
+		}
+		if f.Lang != "" && !strings.EqualFold(ri.Lang, f.Lang) {
+			continue
+		}
+		if f.ClipLength != 0 && ri.ClipLength != f.ClipLength {
+			continue
+		}
+		e, ok := bySession[ri.SessionID]
+		if !ok {
+			e = &leaderboardEntry{Player: publicPlayerID(ri.SessionID), session: ri.SessionID}
+			if s, ok := store.GetSession(ri.SessionID); ok {
+				e.Name = s.Name
+			}
+			bySession[ri.SessionID] = e
+		}
+		e.Score += ri.Points
+		e.Rounds++
+		if ri.Solved {
+			e.Solved++
+		}
+	}
+	out := make([]leaderboardEntry, 0, len(bySession))
+	for _, e := range bySession {
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		if out[i].Solved != out[j].Solved {
+			return out[i].Solved > out[j].Solved
+		}
+		return out[i].Player < out[j].Player
+	})
+	for i := range out {
+		if i > 0 && out[i].Score == out[i-1].Score {
+			out[i].Rank = out[i-1].Rank
+		} else {
+			out[i].Rank = i + 1
+		}
+	}
+	return out
+}
+
+// leaderboardFilterFromQuery reads period, lang and clipLength.
+func leaderboardFilterFromQuery(r *http.Request) (leaderboardFilter, bool) {
+	q := r.URL.Query()
+	f := leaderboardFilter{Period: q.Get("period"), Lang: q.Get("lang")}
+	if f.Period == "" {
+		f.Period = periodAll
+	}
+	if f.Period != periodAll && f.Period != periodWeekly && f.Period != periodDaily {
+		return f, false
+	}
+	f.ClipLength, _ = strconv.Atoi(q.Get("clipLength"))
+	return f, true
+}
+
+// leaderboardHandler returns the top N (limit, default 10) of a board.
+func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	f, ok := leaderboardFilterFromQuery(r)
+	if !ok {
+		http.Error(w, "invalid period, use all, weekly or daily", http.StatusBadRequest)
+		return
+	}
+	limit := 10
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	now := time.Now()
+	entries := leaderboard(f, now)
+	players := len(entries)
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	resp := map[string]interface{}{"period": f.Period, "lang": f.Lang, "clip_length": f.ClipLength, "players": players, "entries": entries}
+	if since := periodStart(f.Period, now); !since.IsZero() {
+		resp["since"] = since
+	}
+	writeJSON(w, resp)
+}
+
+// leaderboardMeHandler returns the caller's own rank on a board.
+func leaderboardMeHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	f, ok := leaderboardFilterFromQuery(r)
+	if !ok {
+		http.Error(w, "invalid period, use all, weekly or daily", http.StatusBadRequest)
+		return
+	}
+	session := sessionFromRequest(r)
+	if session == "" {
+		http.Error(w, "missing session", http.StatusBadRequest)
+		return
+	}
+	entries := leaderboard(f, time.Now())
+	resp := map[string]interface{}{"period": f.Period, "lang": f.Lang, "clip_length": f.ClipLength, "players": len(entries), "ranked": false}
+	for _, e := range entries {
+		if e.session == session {
+			resp["ranked"] = true
+			resp["entry"] = e
+			break
+		}
+	}
+	writeJSON(w, resp)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Match is a fixed length game of rounds sharing one language, clip length,
+// segment and mode. Rounds are created one at a time by /match/next, so
+// RoundIDs only grows once the previous round is finished.
+type Match struct {
+	ID         string    `json:"id"`
+	SessionID  string    `json:"session_id,omitempty"`
+	Lang       string    `json:"lang"`
+	ClipLength int       `json:"clip_length"`
+	Segment    string    `json:"segment"`
+	Mode       string    `json:"mode"`
+	Difficulty string    `json:"difficulty"`
+	Category   string    `json:"category,omitempty"`
+	Playlist   string    `json:"playlist,omitempty"`
+	Size       int       `json:"rounds"`
+	RoundIDs   []string  `json:"round_ids"`
+	CreatedAt  time.Time `json:"created_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+const (
+	defaultMatchSize = 5
+	maxMatchSize     = 20
+)
+
+func (m Match) spec() roundSpec {
+	return roundSpec{Lang: m.Lang, ClipLength: m.ClipLength, Segment: m.Segment, Mode: m.Mode, Difficulty: m.Difficulty, Category: m.Category, Playlist: m.Playlist}
+}
+
+// matchScorecard is the final summary of a match.
+type matchScorecard struct {
+	MatchID     string          `json:"match_id"`
+	Lang        string          `json:"lang"`
+	ClipLength  int             `json:"clip_length"`
+	Mode        string          `json:"mode"`
+	Rounds      []scorecardLine `json:"rounds"`
+	Solved      int             `json:"solved"`
+	TotalPoints int             `json:"total_points"`
+	FinishedAt  time.Time       `json:"finished_at"`
+}
+
+type scorecardLine struct {
+	Index         int     `json:"index"`
+	ID            string  `json:"id"`
+	Title         string  `json:"title"`
+	Artist        string  `json:"artist"`
+	YouTube       string  `json:"youtube"`
+	TitleGuessed  bool    `json:"title_guessed"`
+	ArtistGuessed bool    `json:"artist_guessed"`
+	Revealed      bool    `json:"revealed"`
+	Seconds       float64 `json:"seconds"` // from clip ready to solve or reveal
+	Points        int     `json:"points"`
+}
+
+// roundFinished reports whether a match may move past ri. Rounds whose clip
+// failed or expired count as finished so a match cannot get stuck.
+func roundFinished(ri Round) bool {
+	return ri.Solved || ri.Revealed || ri.Expired || ri.Error != ""
+}
+
+// matchLocks serializes /match/next per match so two concurrent calls
+// cannot both add a round to the same match, while a slow round start in
+// one match doesn't hold up the others.
+var matchLocks = struct {
+	sync.Mutex
+	m map[string]*matchLock
+}{m: map[string]*matchLock{}}
+
+type matchLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockMatch locks the match id and returns the function that unlocks it.
+func lockMatch(id string) func() {
+	matchLocks.Lock()
+	l := matchLocks.m[id]
+	if l == nil {
+		l = &matchLock{}
+		matchLocks.m[id] = l
+	}
+	l.refs++
+	matchLocks.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		matchLocks.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(matchLocks.m, id)
+		}
+		matchLocks.Unlock()
+	}
+}
+
+// scorecard builds the summary of m from its rounds.
+func (m Match) scorecard() matchScorecard {
+	sc := matchScorecard{MatchID: m.ID, Lang: m.Lang, ClipLength: m.ClipLength, Mode: m.Mode, Rounds: []scorecardLine{}, FinishedAt: m.FinishedAt}
+	for i, id := range m.RoundIDs {
+		ri, ok := store.GetRound(id)
+		if !ok {
+			continue
+		}
+		line := scorecardLine{Index: i + 1, ID: ri.ID, Title: ri.Title, Artist: ri.Artist, YouTube: ri.YouTube, TitleGuessed: ri.TitleGuessed, ArtistGuessed: ri.ArtistGuessed, Revealed: ri.Revealed, Points: ri.Points}
+		started := ri.ReadyAt
+		if started.IsZero() {
+			started = ri.CreatedAt
+		}
+		if !ri.FinishedAt.IsZero() && ri.FinishedAt.After(started) {
+			line.Seconds = ri.FinishedAt.Sub(started).Round(time.Millisecond).Seconds()
+		}
+		if ri.Solved {
+			sc.Solved++
+		}
+		sc.TotalPoints += ri.Points
+		sc.Rounds = append(sc.Rounds, line)
+	}
+	return sc
+}
+
+// matchAfterRound finishes the match of ri when ri was its last round and
+// returns the scorecard, or nil if the match is still going.
+func matchAfterRound(ri Round) *matchScorecard {
+	if ri.MatchID == "" || !roundFinished(ri) {
+		return nil
+	}
+	m, ok := store.GetMatch(ri.MatchID)
+	if !ok || len(m.RoundIDs) < m.Size || m.RoundIDs[len(m.RoundIDs)-1] != ri.ID {
+		return nil
+	}
+	if m.FinishedAt.IsZero() {
+		var err error
+		m, err = store.UpdateMatch(m.ID, func(mm *Match) {
+			if mm.FinishedAt.IsZero() {
+				mm.FinishedAt = time.Now()
+			}
+		})
+		if err != nil {
+			return nil
+		}
+	}
+	sc := m.scorecard()
+	return &sc
+}
+
+// matchHandler creates a match on POST with JSON {lang, clip_length, rounds,
+// segment, mode, difficulty, category, playlist} and describes one on GET
+// ?id=, including the scorecard once it is finished.
+func matchHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method != http.MethodPost {
+		m, ok := store.GetMatch(r.URL.Query().Get("id"))
+		if !ok {
+			http.Error(w, "match not found", http.StatusNotFound)
+			return
+		}
+		resp := map[string]interface{}{"match": m, "played": len(m.RoundIDs), "finished": !m.FinishedAt.IsZero()}
+		if !m.FinishedAt.IsZero() {
+			resp["scorecard"] = m.scorecard()
+		}
+		writeJSON(w, resp)
+		return
+	}
+
+	var req struct {
+		Lang       string `json:"lang"`
+		ClipLength int    `json:"clip_length"`
+		Rounds     int    `json:"rounds"`
+		Segment    string `json:"segment"`
+		Mode       string `json:"mode"`
+		Difficulty string `json:"difficulty"`
+		Category   string `json:"category"`
+		Playlist   string `json:"playlist"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	spec, err := normalizeSpec(roundSpec{Lang: req.Lang, ClipLength: req.ClipLength, Segment: req.Segment, Mode: req.Mode, Difficulty: req.Difficulty, Category: req.Category, Playlist: req.Playlist})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Rounds == 0 {
+		req.Rounds = defaultMatchSize
+	}
+	if req.Rounds < 1 || req.Rounds > maxMatchSize {
+		http.Error(w, "rounds must be between 1 and 20", http.StatusBadRequest)
+		return
+	}
+	session := sessionFromRequest(r)
+	if session != "" {
+		if _, ok := store.GetSession(session); !ok {
+			http.Error(w, "session not found, create one with POST /session", http.StatusNotFound)
+			return
+		}
+	}
+
+	m := Match{ID: randomID(8), SessionID: session, Lang: spec.Lang, ClipLength: spec.ClipLength, Segment: spec.Segment, Mode: spec.Mode, Difficulty: spec.Difficulty, Category: spec.Category, Playlist: spec.Playlist, Size: req.Rounds, RoundIDs: []string{}, CreatedAt: time.Now()}
+	if err := store.PutMatch(m); err != nil {
+		http.Error(w, "store error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"match": m, "next_url": "/match/next?id=" + m.ID})
+}
+
+// matchNextHandler serves the rounds of a match in order. While the current
+// round is unfinished it is returned again, so players cannot skip ahead.
+// After the last round it returns the scorecard.
+func matchNextHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	id := r.URL.Query().Get("id")
+	defer lockMatch(id)()
+
+	m, ok := store.GetMatch(id)
+	if !ok {
+		http.Error(w, "match not found", http.StatusNotFound)
+		return
+	}
+	if !ownedBy(r, m.SessionID) {
+		http.Error(w, "match belongs to another session", http.StatusForbidden)
+		return
+	}
+
+	roundResp := func(ri Round, index int) map[string]interface{} {
+		resp := map[string]interface{}{"match_id": m.ID, "index": index, "rounds": m.Size, "id": ri.ID, "clip_url": clipURL(ri.ID), "ready": ri.Ready}
+		if ri.Mode == modeChoice {
+			resp["options"] = ri.Options
+		}
+		return resp
+	}
+	if n := len(m.RoundIDs); n > 0 {
+		cur, ok := store.GetRound(m.RoundIDs[n-1])
+		if ok && !roundFinished(cur) {
+			writeJSON(w, roundResp(cur, n))
+			return
+		}
+		if n >= m.Size {
+			if ok {
+				matchAfterRound(cur)
+			}
+			m, _ = store.GetMatch(m.ID)
+			writeJSON(w, map[string]interface{}{"match_id": m.ID, "finished": true, "scorecard": m.scorecard()})
+			return
+		}
+	}
+
+	ri, err := startRound(m.spec(), func(rr *Round) {
+		rr.SessionID = m.SessionID
+		rr.MatchID = m.ID
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	m, err = store.UpdateMatch(m.ID, func(mm *Match) { mm.RoundIDs = append(mm.RoundIDs, ri.ID) })
+	if err != nil {
+		http.Error(w, "store error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, roundResp(ri, len(m.RoundIDs)))
+}
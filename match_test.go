@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingSource holds back songs for one language until release is closed.
+type blockingSource struct {
+	lang    string
+	release chan struct{}
+}
+
+func (blockingSource) Name() string { return "blocking" }
+
+func (s blockingSource) Next(ctx context.Context, q songQuery) (Candidate, error) {
+	if q.Lang == s.lang {
+		<-s.release
+	}
+	return Candidate(kesariya), nil
+}
+
+func TestMatchNextDoesNotBlockOtherMatches(t *testing.T) {
+	src := blockingSource{lang: "hindi", release: make(chan struct{})}
+	srv := newTestServer(t, src)
+
+	newMatch := func(lang string) string {
+		resp, err := http.Post(srv.URL+"/match", "application/json", strings.NewReader(`{"lang":"`+lang+`","mode":"choice","rounds":2}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out struct {
+			Match Match `json:"match"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		return out.Match.ID
+	}
+	slow, fast := newMatch("hindi"), newMatch("tamil")
+
+	// choice rounds resolve their song before /match/next answers
+	slowDone := make(chan struct{})
+	go func() {
+		if resp, err := http.Get(srv.URL + "/match/next?id=" + slow); err == nil {
+			resp.Body.Close()
+		}
+		close(slowDone)
+	}()
+	defer func() {
+		close(src.release)
+		<-slowDone
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(srv.URL + "/match/next?id=" + fast)
+		if err == nil {
+			resp.Body.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("/match/next of one match waited for another match's round")
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Strictness controls how many typos the matcher forgives.
+type Strictness int
+
+const (
+	StrictnessLenient Strictness = iota
+	StrictnessNormal
+	StrictnessStrict
+)
+
+func (s Strictness) String() string {
+	switch s {
+	case StrictnessLenient:
+		return "lenient"
+	case StrictnessStrict:
+		return "strict"
+	}
+	return "normal"
+}
+
+func parseStrictness(s string) (Strictness, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "lenient":
+		return StrictnessLenient, true
+	case "normal", "":
+		return StrictnessNormal, true
+	case "strict":
+		return StrictnessStrict, true
+	}
+	return StrictnessNormal, false
+}
+
+// Matcher decides whether a free text guess names an answer such as a song
+// title or artist. Both sides are normalized first (case, diacritics,
+// punctuation, "feat." credits and bracketed qualifiers), then compared as a
+// whole and token by token with edit distance limits scaled by length. A
+// guess has to cover a minimum share of the answer's meaningful words.
+// Native script titles are romanized, so "केसरिया" and "Kesariya" match.
+// Common romanization variants ("Tamizh"/"Tamil") are only folded for Indic
+// rounds or when either side is written in Devanagari or Tamil script, so
+// "sip" doesn't pass for "Sheep".
+type Matcher struct {
+	Strictness Strictness
+	Indic      bool // the round's language is usually romanized from an Indian script
+}
+
+// matcher is the process wide matcher, configured by MATCH_STRICTNESS.
+var matcher = Matcher{Strictness: StrictnessNormal}
+
+func matcherFromEnv() Matcher {
+	st, _ := parseStrictness(os.Getenv("MATCH_STRICTNESS"))
+	return Matcher{Strictness: st}
+}
+
+// Reasons a guess was rejected, returned to clients by /guess.
+const (
+	reasonEmpty       = "empty"
+	reasonTooLong     = "too_long"
+	reasonCommonWords = "only_common_words"
+	reasonPartial     = "not_enough_of_answer"
+	reasonExtraWords  = "too_many_other_words"
+	reasonNoMatch     = "no_match"
+)
+
+// maxGuessRunes caps the length of a guess so pasting a paragraph that
+// happens to contain the answer does not win.
+const maxGuessRunes = 100
+
+// minMatchedRunes is how many letters of the answer a guess must get right,
+// so a lone "hi" can't name "Tum Hi Ho". Answers shorter than that need all
+// of their letters.
+const minMatchedRunes = 4
+
+// stopwords are too common to count as knowing a title on their own.
+var stopwords = map[string]bool{
+	// english
+	"a": true, "an": true, "the": true, "of": true, "to": true, "in": true, "on": true, "at": true,
+	"for": true, "and": true, "or": true, "is": true, "it": true, "be": true, "me": true, "my": true,
+	"i": true, "im": true, "you": true, "your": true, "we": true, "us": true, "he": true, "she": true,
+	"this": true, "that": true, "with": true, "by": true, "from": true, "no": true, "oh": true,
+	"love": true, "baby": true, "song": true, "music": true, "official": true, "video": true,
+	// romanized hindi
+	"hai": true, "ka": true, "ki": true, "ke": true, "ko": true, "se": true, "tu": true, "tum": true,
+	"main": true, "mera": true, "meri": true, "mere": true, "na": true, "ho": true, "pyar": true,
+	// romanized tamil
+	"en": true, "un": true, "nee": true, "naan": true, "di": true, "da": true, "kadhal": true,
+}
+
+// matchResult is the outcome of comparing a guess with one answer.
+type matchResult struct {
+	OK       bool
+	Reason   string  // why the guess was rejected, empty when OK
+	Coverage float64 // share of the answer's meaningful words found in the guess
+}
+
+// Match reports whether guess names answer.
+func (m Matcher) Match(guess, answer string) bool {
+	return m.Check(guess, answer).OK
+}
+
+// Check compares guess with answer and explains rejections. Stopwords do
+// not count towards coverage unless the rest of the answer is too short to
+// stand on its own, repeated answer words count once, and guesses that are
+// much longer than the answer are refused outright. At least half of the
+// guess's own words have to belong to the answer, so listing several titles
+// in one guess doesn't win.
+func (m Matcher) Check(guess, answer string) matchResult {
+	if len([]rune(guess)) > maxGuessRunes {
+		return matchResult{Reason: reasonTooLong}
+	}
+	m = m.forScripts(guess, answer)
+	g := normalizeTitle(guess)
+	a := normalizeTitle(answer)
+	if g == "" || a == "" {
+		return matchResult{Reason: reasonEmpty}
+	}
+	gTokens := contentTokens(strings.Fields(g))
+	aTokens := uniqueTokens(contentTokens(strings.Fields(a)))
+	if len(gTokens) == 0 && len(aTokens) > 0 {
+		return matchResult{Reason: reasonCommonWords}
+	}
+	if runeCount(aTokens) < minMatchedRunes {
+		// answers made (almost) only of common words ("You & I", "Tum Hi
+		// Ho") are compared in full
+		gTokens, aTokens = strings.Fields(g), uniqueTokens(strings.Fields(a))
+	}
+	if len(gTokens) > 2*len(aTokens)+2 {
+		return matchResult{Reason: reasonTooLong}
+	}
+	if g == a {
+		return matchResult{OK: true, Coverage: 1}
+	}
+	// whole string, ignoring spacing ("kesari ya" vs "kesariya")
+	gc := m.fold(strings.ReplaceAll(g, " ", ""))
+	ac := m.fold(strings.ReplaceAll(a, " ", ""))
+	if levenshtein(gc, ac) <= m.maxEdits(len([]rune(ac))) {
+		return matchResult{OK: true, Coverage: 1}
+	}
+	cov, matched := m.coverage(gTokens, aTokens)
+	switch {
+	case cov >= m.minCoverage() && matched >= min(minMatchedRunes, runeCount(aTokens)):
+		if m.precision(gTokens, aTokens) < minPrecision {
+			return matchResult{Reason: reasonExtraWords, Coverage: cov}
+		}
+		return matchResult{OK: true, Coverage: cov}
+	case cov > 0:
+		return matchResult{Reason: reasonPartial, Coverage: cov}
+	}
+	return matchResult{Reason: reasonNoMatch}
+}
+
+// bestMatch picks the most useful result when a guess is checked against
+// several answers: any match wins, otherwise the most specific rejection.
+func bestMatch(results ...matchResult) matchResult {
+	rank := map[string]int{reasonNoMatch: 0, reasonEmpty: 1, reasonCommonWords: 2, reasonTooLong: 3, reasonExtraWords: 4, reasonPartial: 5}
+	var best matchResult
+	for i, r := range results {
+		if r.OK {
+			return r
+		}
+		if i == 0 || rank[r.Reason] > rank[best.Reason] {
+			best = r
+		}
+	}
+	return best
+}
+
+// contentTokens drops stopwords from a token list.
+func contentTokens(tokens []string) []string {
+	var out []string
+	for _, t := range tokens {
+		if !stopwords[t] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// uniqueTokens drops repeated tokens, keeping the first of each.
+func uniqueTokens(tokens []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, t := range tokens {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func runeCount(tokens []string) int {
+	n := 0
+	for _, t := range tokens {
+		n += len([]rune(t))
+	}
+	return n
+}
+
+// coverage returns the fraction of answer tokens that some guess token
+// matches and how many letters those answer tokens have.
+func (m Matcher) coverage(guess, answer []string) (float64, int) {
+	if len(answer) == 0 {
+		return 0, 0
+	}
+	matched, runes := 0, 0
+	for _, at := range answer {
+		for _, gt := range guess {
+			if m.tokenMatch(gt, at) {
+				matched++
+				runes += len([]rune(at))
+				break
+			}
+		}
+	}
+	return float64(matched) / float64(len(answer)), runes
+}
+
+// minPrecision is the share of a guess's words that must match the answer.
+const minPrecision = 0.5
+
+// precision returns the fraction of distinct guess tokens that match some
+// answer token.
+func (m Matcher) precision(guess, answer []string) float64 {
+	guess = uniqueTokens(guess)
+	if len(guess) == 0 {
+		return 0
+	}
+	matched := 0
+	for _, gt := range guess {
+		for _, at := range answer {
+			if m.tokenMatch(gt, at) {
+				matched++
+				break
+			}
+		}
+	}
+	return float64(matched) / float64(len(guess))
+}
+
+func (m Matcher) tokenMatch(guess, answer string) bool {
+	if guess == answer {
+		return true
+	}
+	guess, answer = m.fold(guess), m.fold(answer)
+	if guess == answer {
+		return true
+	}
+	return levenshtein(guess, answer) <= m.maxEdits(len([]rune(answer)))
+}
+
+// forScripts returns m with romanization folding turned on when either
+// string contains Devanagari or Tamil script.
+func (m Matcher) forScripts(a, b string) Matcher {
+	if !m.Indic && (hasIndicScript(a) || hasIndicScript(b)) {
+		m.Indic = true
+	}
+	return m
+}
+
+// fold applies foldRomanization for Indic comparisons and leaves s alone
+// otherwise.
+func (m Matcher) fold(s string) string {
+	if !m.Indic {
+		return s
+	}
+	return foldRomanization(s)
+}
+
+// maxEdits is the number of typos allowed in a word of n letters. Very short
+// words must match exactly.
+func (m Matcher) maxEdits(n int) int {
+	if n <= 3 {
+		return 0
+	}
+	switch m.Strictness {
+	case StrictnessLenient:
+		return n / 3
+	case StrictnessStrict:
+		return n / 8
+	}
+	return n / 4
+}
+
+// minCoverage is the share of the answer's words a guess has to contain.
+func (m Matcher) minCoverage() float64 {
+	switch m.Strictness {
+	case StrictnessLenient:
+		return 0.5
+	case StrictnessStrict:
+		return 1
+	}
+	return 0.66
+}
+
+var (
+	featRe     = regexp.MustCompile(`(?i)[\s(\[]+(feat\.?|ft\.?|featuring|prod\.?)\s.*$`)
+	bracketsRe = regexp.MustCompile(`\([^)]*\)|\[[^\]]*\]`)
+)
+
+// normalizeTitle lowercases s, romanizes Devanagari and Tamil, strips
+// accents from Latin letters, drops "feat." credits and bracketed
+// qualifiers like "(From "Film")" and reduces punctuation to single spaces.
+func normalizeTitle(s string) string {
+	s = strings.TrimSpace(s)
+	s = featRe.ReplaceAllString(s, "")
+	if t := strings.TrimSpace(bracketsRe.ReplaceAllString(s, " ")); t != "" {
+		s = t
+	}
+	s = transliterate(s)
+	s = strings.ReplaceAll(s, "&", " and ")
+
+	var b strings.Builder
+	var base rune
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r) && unicode.Is(unicode.Latin, base):
+			// accent on a Latin letter: é -> e
+		case r == '\'' || r == '’' || r == '`':
+			// don't -> dont
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.IsMark(r):
+			b.WriteRune(unicode.ToLower(r))
+			if !unicode.IsMark(r) {
+				base = r
+			}
+		default:
+			b.WriteRune(' ')
+			base = 0
+		}
+	}
+	return strings.Join(strings.Fields(norm.NFC.String(b.String())), " ")
+}
+
+// levenshtein returns the edit distance between a and b in runes.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// Partial credit for naming the title and the artist. Knowing the song is
+// worth more than knowing the singer.
+const (
+	titleCredit  = 0.7
+	artistCredit = 0.3
+)
+
+// guessVerdict is the outcome of a guess against a round's answer.
+type guessVerdict struct {
+	TitleCorrect  bool
+	ArtistCorrect bool
+	Reason        string // why the title was not accepted
+}
+
+// Credit is the share of the round's points the verdict earns.
+func (v guessVerdict) Credit() float64 {
+	c := 0.0
+	if v.TitleCorrect {
+		c += titleCredit
+	}
+	if v.ArtistCorrect {
+		c += artistCredit
+	}
+	return c
+}
+
+// guessSeparators split combined guesses like "Kesariya by Arijit Singh".
+var guessSeparators = []string{" by ", " - ", " – ", " — ", " / ", " | ", ", "}
+
+// Judge checks title and artist independently. titles holds the official
+// title followed by any accepted aliases. Besides the whole guess it tries
+// "title by artist" style splits in both orders, so either part can be
+// right on its own, and the guess without the artist's words, so naming the
+// artist doesn't count as extra words against the title.
+func (m Matcher) Judge(guess string, titles []string, artist string) guessVerdict {
+	checkTitle := func(g string) matchResult {
+		var res []matchResult
+		for _, t := range titles {
+			res = append(res, m.Check(g, t))
+		}
+		return bestMatch(res...)
+	}
+	titleRes := checkTitle(guess)
+	artistOK := artist != "" && m.Match(guess, artist)
+	if artistOK && !titleRes.OK {
+		if rest := m.withoutWords(guess, artist); rest != "" {
+			if r := checkTitle(rest); r.OK {
+				titleRes = r
+			}
+		}
+	}
+	for _, sep := range guessSeparators {
+		i := indexFold(guess, sep)
+		if i < 0 {
+			continue
+		}
+		left, right := guess[:i], guess[i+len(sep):]
+		for _, pair := range [][2]string{{left, right}, {right, left}} {
+			if r := checkTitle(pair[0]); r.OK {
+				titleRes = r
+			} else if !titleRes.OK {
+				titleRes = bestMatch(titleRes, r)
+			}
+			if artist != "" && m.Match(pair[1], artist) {
+				artistOK = true
+			}
+		}
+	}
+	v := guessVerdict{TitleCorrect: titleRes.OK, ArtistCorrect: artistOK}
+	if !v.TitleCorrect {
+		v.Reason = titleRes.Reason
+	}
+	return v
+}
+
+// withoutWords drops the words of guess that match a word of other.
+func (m Matcher) withoutWords(guess, other string) string {
+	m = m.forScripts(guess, other)
+	drop := strings.Fields(normalizeTitle(other))
+	var keep []string
+	for _, gt := range strings.Fields(normalizeTitle(guess)) {
+		found := false
+		for _, ot := range drop {
+			if m.tokenMatch(gt, ot) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			keep = append(keep, gt)
+		}
+	}
+	return strings.Join(keep, " ")
+}
+
+// indexFold is strings.Index ignoring case. It searches s itself rather than
+// a lowercased copy, whose byte offsets can differ from s ("Ⱥ" lowercases to
+// a longer "ⱥ"), so the index is always safe to slice s with.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJudgeSeparatorAfterCaseChange(t *testing.T) {
+	// "Ⱥ" is 2 bytes but lowercases to the 3 byte "ⱥ", which used to push
+	// the separator index past the end of the guess.
+	v := matcher.Judge("ȺȺȺȺȺȺȺȺ by a", []string{"Kesariya"}, "Arijit Singh")
+	if v.TitleCorrect || v.ArtistCorrect {
+		t.Errorf("Judge = %+v, want nothing correct", v)
+	}
+	v = matcher.Judge("Kesariya BY Arijit Singh", []string{"Kesariya"}, "Arijit Singh")
+	if !v.TitleCorrect || !v.ArtistCorrect {
+		t.Errorf("Judge = %+v, want title and artist correct", v)
+	}
+}
+
+func TestCheckShortContentWords(t *testing.T) {
+	tests := []struct {
+		guess, answer string
+		ok            bool
+	}{
+		// "tum" and "ho" are stopwords, "hi" alone is not the title
+		{"hi", "Tum Hi Ho", false},
+		{"tum hi ho", "Tum Hi Ho", true},
+		// a repeated word counts once
+		{"hua", "Pyar Hua Iqrar Hua", false},
+		{"iqrar hua", "Pyar Hua Iqrar Hua", true},
+		{"naatu", "Naatu Naatu", true},
+	}
+	for _, st := range []Strictness{StrictnessLenient, StrictnessNormal, StrictnessStrict} {
+		m := Matcher{Strictness: st}
+		for _, tt := range tests {
+			if got := m.Check(tt.guess, tt.answer); got.OK != tt.ok {
+				t.Errorf("%v: Check(%q, %q) = %+v, want ok %v", st, tt.guess, tt.answer, got, tt.ok)
+			}
+		}
+	}
+}
+
+func TestCheckSongTitles(t *testing.T) {
+	tests := []struct {
+		name          string
+		guess, answer string
+		ok            bool
+		reason        string
+	}{
+		{"exact", "Kesariya", "Kesariya", true, ""},
+		{"case and spacing", "  flowers ", "Flowers", true, ""},
+		{"romanization variant", "kesaria", "Kesariya", true, ""},
+		{"dropped vowel", "kesriya", "Kesariya", true, ""},
+		{"one typo", "flowrs", "Flowers", true, ""},
+		{"too many typos", "flwrs", "Flowers", false, reasonNoMatch},
+		{"accents", "cafe", "Café", true, ""},
+		{"apostrophe", "dont start now", "Don't Start Now", true, ""},
+		{"hyphen joined", "antihero", "Anti-Hero", true, ""},
+		{"hyphen split", "anti hero", "Anti-Hero", true, ""},
+		{"feat credit", "despacito", "Despacito (feat. Daddy Yankee)", true, ""},
+		{"film qualifier", "tum kya mile", `Tum Kya Mile (From "Rocky Aur Rani Kii Prem Kahaani")`, true, ""},
+		{"ampersand", "you and i", "You & I", true, ""},
+		{"most words", "naatu naatu", "Naatu Naatu", true, ""},
+		{"half the title", "calm", "Calm Down", false, reasonPartial},
+		{"wrong song", "chaleya", "Kesariya", false, reasonNoMatch},
+		{"empty", "   ", "Kesariya", false, reasonEmpty},
+
+		// stopwords
+		{"only stopwords", "the", "The Night We Met", false, reasonCommonWords},
+		{"stopwords around the title", "the night we met", "The Night We Met", true, ""},
+		{"only common hindi words", "tum ho", "Tum Hi Ho", false, reasonCommonWords},
+		{"lone short word", "hi", "Tum Hi Ho", false, reasonPartial},
+		{"repeated word", "hua", "Pyar Hua Iqrar Hua", false, reasonPartial},
+
+		// length caps
+		{"few extra words", "blinding lights the weeknd", "Blinding Lights", true, ""},
+		{"word list", "kesariya chaleya hukum naatu flowers calm down anti hero", "Kesariya", false, reasonTooLong},
+		{"paragraph", strings.Repeat("kesariya ", 12), "Kesariya", false, reasonTooLong},
+		{"title among others", "kesariya chaleya hukum flowers", "Kesariya", false, reasonExtraWords},
+		{"two word title among others", "calm down flowers hukum kesariya chaleya", "Calm Down", false, reasonExtraWords},
+		{"artist among others", "arijit shreya sonu badshah", "Arijit Singh", false, reasonPartial},
+		{"full artist among others", "arijit singh shreya ghoshal sonu nigam", "Arijit Singh", false, reasonExtraWords},
+
+		// native scripts against romanized spellings
+		{"devanagari answer", "kesariya", "केसरिया", true, ""},
+		{"devanagari phrase", "kesariya tera", "केसरिया तेरा", true, ""},
+		{"devanagari guess", "तुम ही हो", "Tum Hi Ho", true, ""},
+		{"devanagari stopwords", "tum hi ho", "तुम ही हो", true, ""},
+		{"tamil answer", "vaathi coming", "வாத்தி கம்மிங்", true, ""},
+		{"tamil long vowels", "kaavaalaa", "காவாலா", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matcher.Check(tt.guess, tt.answer)
+			if got.OK != tt.ok || got.Reason != tt.reason {
+				t.Errorf("Check(%q, %q) = %+v, want ok %v reason %q", tt.guess, tt.answer, got, tt.ok, tt.reason)
+			}
+		})
+	}
+}
+
+func TestRomanFoldsOnlyForIndic(t *testing.T) {
+	tests := []struct {
+		lang, guess, answer string
+		ok                  bool
+	}{
+		{"English", "sip", "Sheep", false},
+		{"English", "tamil", "Tamizh", false},
+		{"Tamil", "tamil", "Tamizh", true},
+		{"Hindi", "dilvale", "Dilwale", true},
+		// native script on either side turns folding on whatever the language
+		{"English", "tamil", "தமிழ்", true},
+	}
+	for _, tt := range tests {
+		if got := matcherFor(Round{Lang: tt.lang}).Check(tt.guess, tt.answer); got.OK != tt.ok {
+			t.Errorf("%s: Check(%q, %q) = %+v, want ok %v", tt.lang, tt.guess, tt.answer, got, tt.ok)
+		}
+	}
+}
+
+func TestCheckStrictness(t *testing.T) {
+	tests := []struct {
+		guess, answer           string
+		lenient, normal, strict bool
+	}{
+		{"kesariya", "Kesariya", true, true, true},
+		{"kasariyo", "Kesariya", true, true, false},
+		{"calm", "Calm Down", true, false, false},
+		{"blinding", "Blinding Lights", true, false, false},
+		{"iqrar", "Pyar Hua Iqrar Hua", true, false, false},
+	}
+	for _, tt := range tests {
+		for st, want := range map[Strictness]bool{StrictnessLenient: tt.lenient, StrictnessNormal: tt.normal, StrictnessStrict: tt.strict} {
+			if got := (Matcher{Strictness: st}).Match(tt.guess, tt.answer); got != want {
+				t.Errorf("%v: Match(%q, %q) = %v, want %v", st, tt.guess, tt.answer, got, want)
+			}
+		}
+	}
+}
+
+func TestJudge(t *testing.T) {
+	tests := []struct {
+		name          string
+		guess         string
+		titles        []string
+		artist        string
+		title, singer bool
+	}{
+		{"title only", "Kesariya", []string{"Kesariya"}, "Arijit Singh", true, false},
+		{"artist only", "arijit singh", []string{"Kesariya"}, "Arijit Singh", false, true},
+		{"title by artist", "Kesariya by Arijit Singh", []string{"Kesariya"}, "Arijit Singh", true, true},
+		{"artist - title", "The Weeknd - Blinding Lights", []string{"Blinding Lights"}, "The Weeknd", true, true},
+		{"comma", "Hukum, Anirudh", []string{"Hukum"}, "Anirudh Ravichander", true, false},
+		{"wrong title right artist", "Chaleya by Arijit Singh", []string{"Kesariya"}, "Arijit Singh", false, true},
+		{"alias", "Jailer Hukum", []string{"Hukum", "Hukum Jailer"}, "Anirudh Ravichander", true, false},
+		{"native script title", "केसरिया by arijit", []string{"Kesariya"}, "Arijit Singh", true, false},
+		{"title and artist without separator", "Kesariya Arijit Singh", []string{"Kesariya"}, "Arijit Singh", true, true},
+		{"titles and artist", "Kesariya Chaleya Hukum Arijit Singh", []string{"Kesariya"}, "Arijit Singh", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := matcher.Judge(tt.guess, tt.titles, tt.artist)
+			if v.TitleCorrect != tt.title || v.ArtistCorrect != tt.singer {
+				t.Errorf("Judge(%q) = %+v, want title %v artist %v", tt.guess, v, tt.title, tt.singer)
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// VideoInfo is the subset of yt-dlp metadata the game relies on.
+type VideoInfo struct {
+	ID       string
+	URL      string
+	Title    string
+	Uploader string
+	Duration int
+	// Track and Artist are YouTube Music metadata, set on some music videos.
+	Track  string
+	Artist string
+}
+
+// PlaylistInfo is a YouTube playlist and its entries, as far as a flat
+// listing knows them.
+type PlaylistInfo struct {
+	Title   string
+	Entries []VideoInfo
+}
+
+// Progress is how far a download or trim has come. Stage is filled in by
+// download10sClip; backends only report Percent and the byte counts they know.
+type Progress struct {
+	Stage      string  `json:"stage"`
+	Percent    float64 `json:"percent"`
+	Bytes      int64   `json:"bytes,omitempty"`
+	TotalBytes int64   `json:"total_bytes,omitempty"`
+}
+
+// ProgressFunc receives progress updates. Backends may call it often and
+// from the goroutine running the command; it must not be nil.
+type ProgressFunc func(Progress)
+
+// Downloader fetches the audio of a video into a directory.
+type Downloader interface {
+	// Download stores the best audio stream of url in dir and returns the file path.
+	Download(ctx context.Context, url, dir string, progress ProgressFunc) (string, error)
+}
+
+// Transcoder cuts a playable clip out of a downloaded file.
+type Transcoder interface {
+	// Trim writes length seconds of in, starting at start, into dir and
+	// returns the path of the clip.
+	Trim(ctx context.Context, in, dir string, start, length int, progress ProgressFunc) (string, error)
+}
+
+// Prober looks up video metadata without downloading anything.
+type Prober interface {
+	// Search returns up to n results for a free text query.
+	Search(ctx context.Context, query string, n int) ([]VideoInfo, error)
+	// Probe returns the metadata of a single video.
+	Probe(ctx context.Context, url string) (VideoInfo, error)
+	// Playlist lists the videos of a playlist without probing each one.
+	Playlist(ctx context.Context, url string) (PlaylistInfo, error)
+}
+
+// MediaBackend bundles the three media capabilities the server needs.
+type MediaBackend interface {
+	Downloader
+	Transcoder
+	Prober
+}
+
+// media is the backend used by the server. It defaults to yt-dlp/ffmpeg and
+// can be switched to the offline fake with MEDIA_BACKEND=fake.
+var media MediaBackend = execMedia{}
+
+func newMediaBackend(name string) (MediaBackend, error) {
+	switch name {
+	case "", "exec":
+		return execMedia{}, nil
+	case "fake":
+		return newFakeMedia(), nil
+	}
+	return nil, fmt.Errorf("unknown media backend %q", name)
+}
+
+// execMedia shells out to yt-dlp and ffmpeg, which must be on PATH.
+type execMedia struct{}
+
+func (execMedia) Download(ctx context.Context, url, dir string, progress ProgressFunc) (string, error) {
+	log.Printf("downloading audio for %s into %s", url, dir)
+	// download best audio using yt-dlp
+	// prefer to suppress warnings which can leak into output
+	cmd := exec.CommandContext(ctx, "yt-dlp", "--no-warnings", "--newline", "-f", "bestaudio", "-o", "%(id)s.%(ext)s", url)
+	cmd.Dir = dir
+	out, err := runWithLines(cmd, func(line string) {
+		if p, ok := parseYtDlpProgress(line); ok {
+			progress(p)
+		}
+	})
+	log.Printf("yt-dlp download output (truncated): %s", short(string(out), 800))
+	if err != nil {
+		log.Printf("yt-dlp download error: %v", err)
+		return "", fmt.Errorf("yt-dlp error: %v - %s", err, string(out))
+	}
+	// find downloaded file
+	files, _ := os.ReadDir(dir)
+	for _, f := range files {
+		if !f.IsDir() {
+			return filepath.Join(dir, f.Name()), nil
+		}
+	}
+	return "", fmt.Errorf("no file downloaded")
+}
+
+func (execMedia) Trim(ctx context.Context, in, dir string, start, length int, progress ProgressFunc) (string, error) {
+	outPath := filepath.Join(dir, "clip.mp3")
+	// trim to specified length (in seconds); -progress reports key=value
+	// lines on stdout while the clip is encoded
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-nostats", "-progress", "pipe:1", "-i", in, "-ss", fmt.Sprintf("%d", start), "-t", fmt.Sprintf("%d", length), "-acodec", "libmp3lame", outPath)
+	var fp ffmpegProgress
+	out, err := runWithLines(cmd, func(line string) {
+		if p, ok := fp.parse(line, length); ok {
+			progress(p)
+		}
+	})
+	log.Printf("ffmpeg output (truncated): %s", short(string(out), 800))
+	if err != nil {
+		log.Printf("ffmpeg error: %v", err)
+		return "", fmt.Errorf("ffmpeg error: %v - %s", err, string(out))
+	}
+	return outPath, nil
+}
+
+func (execMedia) Search(ctx context.Context, query string, n int) ([]VideoInfo, error) {
+	cmd := exec.CommandContext(ctx, "yt-dlp", "--no-warnings", "-J", fmt.Sprintf("ytsearch%d:%s", n, query))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("yt-dlp search error: %v", err)
+	}
+	info, err := parseJSONWithRecovery(out)
+	if err != nil {
+		return nil, fmt.Errorf("yt-dlp search parse error: %v", err)
+	}
+	var results []VideoInfo
+	if entries, ok := info["entries"].([]interface{}); ok {
+		for _, e := range entries {
+			if m, ok := e.(map[string]interface{}); ok {
+				results = append(results, videoInfoFromJSON(m))
+			}
+		}
+	}
+	// fallback to top-level fields
+	if len(results) == 0 {
+		if v := videoInfoFromJSON(info); v.URL != "" {
+			results = append(results, v)
+		}
+	}
+	return results, nil
+}
+
+func (execMedia) Probe(ctx context.Context, url string) (VideoInfo, error) {
+	cmd := exec.CommandContext(ctx, "yt-dlp", "--no-warnings", "-J", url)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	info, err := parseJSONWithRecovery(out)
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	v := videoInfoFromJSON(info)
+	if v.URL == "" {
+		v.URL = url
+	}
+	return v, nil
+}
+
+func (execMedia) Playlist(ctx context.Context, url string) (PlaylistInfo, error) {
+	cmd := exec.CommandContext(ctx, "yt-dlp", "--no-warnings", "--flat-playlist", "-J", url)
+	out, err := cmd.Output()
+	if err != nil {
+		return PlaylistInfo{}, fmt.Errorf("yt-dlp playlist error: %v", err)
+	}
+	info, err := parseJSONWithRecovery(out)
+	if err != nil {
+		return PlaylistInfo{}, fmt.Errorf("yt-dlp playlist parse error: %v", err)
+	}
+	var p PlaylistInfo
+	p.Title, _ = info["title"].(string)
+	entries, ok := info["entries"].([]interface{})
+	if !ok {
+		return PlaylistInfo{}, fmt.Errorf("%s is not a playlist", url)
+	}
+	for _, e := range entries {
+		if m, ok := e.(map[string]interface{}); ok {
+			p.Entries = append(p.Entries, videoInfoFromJSON(m))
+		}
+	}
+	return p, nil
+}
+
+// videoInfoFromJSON picks the fields we use out of a yt-dlp -J object.
+// Flat playlist entries only have "url" and "channel" instead of
+// "webpage_url" and "uploader".
+func videoInfoFromJSON(m map[string]interface{}) VideoInfo {
+	var v VideoInfo
+	v.ID, _ = m["id"].(string)
+	v.URL, _ = m["webpage_url"].(string)
+	if u, _ := m["url"].(string); v.URL == "" && strings.HasPrefix(u, "http") {
+		v.URL = u
+	}
+	v.Title, _ = m["title"].(string)
+	v.Uploader, _ = m["uploader"].(string)
+	if v.Uploader == "" {
+		v.Uploader, _ = m["channel"].(string)
+	}
+	v.Track, _ = m["track"].(string)
+	v.Artist, _ = m["artist"].(string)
+	for _, key := range []string{"duration", "duration_seconds", "length"} {
+		if d, ok := m[key].(float64); ok {
+			v.Duration = int(d)
+			break
+		}
+	}
+	if v.ID == "" {
+		v.ID = extractYouTubeID(v.URL)
+	}
+	return v
+}
+
+// runWithLines runs cmd, calling onLine for every line it writes to stdout
+// or stderr, and returns the combined output like CombinedOutput. Carriage
+// returns count as line breaks so redrawn progress lines are seen too.
+func runWithLines(cmd *exec.Cmd, onLine func(string)) ([]byte, error) {
+	pr, pw := io.Pipe()
+	var out bytes.Buffer
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		done <- err
+	}()
+	sc := bufio.NewScanner(pr)
+	sc.Split(scanLinesOrCR)
+	for sc.Scan() {
+		out.Write(sc.Bytes())
+		out.WriteByte('\n')
+		onLine(sc.Text())
+	}
+	// drain whatever a too long line left behind so the command can exit
+	io.Copy(io.Discard, pr)
+	return out.Bytes(), <-done
+}
+
+// scanLinesOrCR is bufio.ScanLines that also splits on a lone '\r'.
+func scanLinesOrCR(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// ytDlpProgressRe matches yt-dlp --newline progress lines such as
+// "[download]  42.3% of ~  3.51MiB at  1.20MiB/s ETA 00:02".
+var ytDlpProgressRe = regexp.MustCompile(`^\[download\]\s+([\d.]+)% of\s+~?\s*([\d.]+)\s*([KMGT]?i?B)`)
+
+func parseYtDlpProgress(line string) (Progress, bool) {
+	m := ytDlpProgressRe.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return Progress{}, false
+	}
+	pct, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return Progress{}, false
+	}
+	size, _ := strconv.ParseFloat(m[2], 64)
+	total := int64(size * byteUnit(m[3]))
+	return Progress{Percent: pct, Bytes: int64(float64(total) * pct / 100), TotalBytes: total}, true
+}
+
+// byteUnit returns the multiplier of a yt-dlp size suffix (KiB, MB...).
+func byteUnit(u string) float64 {
+	base := 1000.0
+	if strings.Contains(u, "i") {
+		base = 1024
+	}
+	switch u[0] {
+	case 'K':
+		return base
+	case 'M':
+		return base * base
+	case 'G':
+		return base * base * base
+	case 'T':
+		return base * base * base * base
+	}
+	return 1
+}
+
+// ffmpegProgress accumulates one block of "-progress" key=value lines and
+// yields a Progress when the block ends with "progress=continue|end".
+type ffmpegProgress struct {
+	outTime float64 // seconds of output written
+	size    int64
+}
+
+func (fp *ffmpegProgress) parse(line string, length int) (Progress, bool) {
+	key, val, ok := strings.Cut(strings.TrimSpace(line), "=")
+	if !ok {
+		return Progress{}, false
+	}
+	switch key {
+	case "out_time_us", "out_time_ms": // both are microseconds
+		if us, err := strconv.ParseInt(val, 10, 64); err == nil {
+			fp.outTime = float64(us) / 1e6
+		}
+	case "total_size":
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil {
+			fp.size = n
+		}
+	case "progress":
+		pct := 100.0
+		if val != "end" && length > 0 {
+			pct = min(max(fp.outTime/float64(length)*100, 0), 100)
+		}
+		return Progress{Percent: pct, Bytes: fp.size}, true
+	}
+	return Progress{}, false
+}
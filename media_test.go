@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseYtDlpProgress(t *testing.T) {
+	tests := []struct {
+		line string
+		ok   bool
+		want Progress
+	}{
+		{"[download]  42.5% of    4.00MiB at  1.20MiB/s ETA 00:02", true, Progress{Percent: 42.5, Bytes: 1782579, TotalBytes: 4194304}},
+		{"[download]   0.0% of ~  2.00KiB at Unknown B/s ETA Unknown", true, Progress{Percent: 0, Bytes: 0, TotalBytes: 2048}},
+		{"[download] 100% of 3.00MB in 00:00:02 at 1.50MB/s", true, Progress{Percent: 100, Bytes: 3000000, TotalBytes: 3000000}},
+		{"[download] Destination: /tmp/songclip1/abc.webm", false, Progress{}},
+		{"[youtube] abc: Downloading webpage", false, Progress{}},
+		{"", false, Progress{}},
+	}
+	for _, tt := range tests {
+		got, ok := parseYtDlpProgress(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseYtDlpProgress(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFfmpegProgress(t *testing.T) {
+	out := `frame=0
+total_size=48000
+out_time_us=2500000
+out_time=00:00:02.500000
+progress=continue
+total_size=96000
+out_time_ms=7500000
+progress=continue
+total_size=160044
+out_time_us=10000000
+progress=end`
+	want := []Progress{
+		{Percent: 25, Bytes: 48000},
+		{Percent: 75, Bytes: 96000},
+		{Percent: 100, Bytes: 160044},
+	}
+	var fp ffmpegProgress
+	var got []Progress
+	for _, line := range strings.Split(out, "\n") {
+		if p, ok := fp.parse(line, 10); ok {
+			got = append(got, p)
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d updates %+v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("update %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFfmpegProgressCapsPercent(t *testing.T) {
+	var fp ffmpegProgress
+	fp.parse("out_time_us=12000000", 10)
+	if p, _ := fp.parse("progress=continue", 10); p.Percent != 100 {
+		t.Errorf("percent past the clip length = %v, want 100", p.Percent)
+	}
+	fp.parse("out_time_us=-5000", 10)
+	if p, _ := fp.parse("progress=continue", 10); p.Percent != 0 {
+		t.Errorf("negative out time = %v, want 0", p.Percent)
+	}
+}
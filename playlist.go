@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Playlist is a user supplied song list that rounds can be played from
+// instead of Gemini's. Songs without a YouTube URL are looked up and
+// validated like cached Gemini songs when a round needs them. A Replay
+// playlist skips the used video check and starts over after its last song.
+type Playlist struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Lang      string    `json:"lang"`
+	Source    string    `json:"source,omitempty"` // YouTube playlist it was imported from
+	Replay    bool      `json:"replay,omitempty"`
+	Songs     []Song    `json:"songs"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// playlistSummary is a Playlist without its songs, for listings.
+type playlistSummary struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Lang      string    `json:"lang"`
+	Source    string    `json:"source,omitempty"`
+	Replay    bool      `json:"replay,omitempty"`
+	Songs     int       `json:"songs"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (p Playlist) summary() playlistSummary {
+	return playlistSummary{ID: p.ID, Name: p.Name, Lang: p.Lang, Source: p.Source, Replay: p.Replay, Songs: len(p.Songs), CreatedAt: p.CreatedAt}
+}
+
+const (
+	maxPlaylistSongs = 500
+	maxPlaylistBytes = 1 << 20
+)
+
+// playlistSource walks uploaded playlists with the same cursor and
+// validation as the Gemini cache. Only Replay playlists skip the used
+// video check. Playlists never change once stored, so a cache is loaded
+// once.
+type playlistSource struct {
+	mu     sync.Mutex
+	caches map[string]*songCache
+}
+
+// playlistSongs serves every playlist round.
+var playlistSongs = &playlistSource{}
+
+func (p *playlistSource) Name() string { return "playlist" }
+
+func (p *playlistSource) Next(ctx context.Context, q songQuery) (Candidate, error) {
+	if q.Playlist == "" {
+		return Candidate{}, errSourceUnavailable
+	}
+	c, err := p.cache(q)
+	if err != nil {
+		return Candidate{}, err
+	}
+	cand, err := c.next(ctx)
+	if err != nil {
+		return Candidate{}, fmt.Errorf("playlist %s has no playable songs: %v", q.Playlist, err)
+	}
+	return cand, nil
+}
+
+// cache returns the cache of q's playlist, loading it from the store on
+// first use.
+func (p *playlistSource) cache(q songQuery) (*songCache, error) {
+	key := q.key()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.caches[key]; ok {
+		return c, nil
+	}
+	pl, ok := store.GetPlaylist(q.Playlist)
+	if !ok {
+		return nil, fmt.Errorf("playlist %q not found", q.Playlist)
+	}
+	if p.caches == nil {
+		p.caches = map[string]*songCache{}
+	}
+	c := &songCache{key: key, query: q, replay: pl.Replay}
+	c.load(pl.Songs)
+	p.caches[key] = c
+	return c, nil
+}
+
+// parsePlaylistCSV reads title,artist[,youtube] rows. A first row with a
+// "title" column is a header and may put the columns in any order.
+func parsePlaylistCSV(r io.Reader) ([]Song, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	cols := map[string]int{"title": 0, "artist": 1, "youtube": 2}
+	if len(records) > 0 && isPlaylistHeader(records[0]) {
+		cols = map[string]int{}
+		for i, h := range records[0] {
+			h = strings.ToLower(strings.TrimSpace(h))
+			if h == "url" {
+				h = "youtube"
+			}
+			cols[h] = i
+		}
+		records = records[1:]
+	}
+	field := func(rec []string, name string) string {
+		i, ok := cols[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return rec[i]
+	}
+	songs := make([]Song, 0, len(records))
+	for _, rec := range records {
+		songs = append(songs, Song{Title: field(rec, "title"), Artist: field(rec, "artist"), YouTube: field(rec, "youtube")})
+	}
+	return songs, nil
+}
+
+func isPlaylistHeader(rec []string) bool {
+	for _, h := range rec {
+		if strings.EqualFold(strings.TrimSpace(h), "title") {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanPlaylistSongs trims the entries and rejects ones the game can't
+// use. Blank rows are skipped.
+func cleanPlaylistSongs(songs []Song) ([]Song, error) {
+	out := make([]Song, 0, len(songs))
+	for i, s := range songs {
+		s.Title = strings.TrimSpace(s.Title)
+		s.Artist = strings.TrimSpace(s.Artist)
+		s.YouTube = strings.TrimSpace(s.YouTube)
+		if s.Title == "" && s.Artist == "" && s.YouTube == "" {
+			continue
+		}
+		if s.Title == "" {
+			return nil, fmt.Errorf("entry %d has no title", i+1)
+		}
+		if s.YouTube != "" && extractYouTubeID(s.YouTube) == "" {
+			return nil, fmt.Errorf("entry %d: %q is not a YouTube URL", i+1, s.YouTube)
+		}
+		out = append(out, s)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("playlist has no songs")
+	}
+	if len(out) > maxPlaylistSongs {
+		return nil, fmt.Errorf("playlist has %d songs, the limit is %d", len(out), maxPlaylistSongs)
+	}
+	return out, nil
+}
+
+// readPlaylist parses a POST /playlists body: JSON {name, lang, replay,
+// songs}, a bare JSON array of songs or CSV. name, lang and replay may also
+// be given in the query string.
+func readPlaylist(r *http.Request) (Playlist, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPlaylistBytes+1))
+	if err != nil {
+		return Playlist{}, err
+	}
+	if len(body) > maxPlaylistBytes {
+		return Playlist{}, fmt.Errorf("playlist is larger than %d bytes", maxPlaylistBytes)
+	}
+	replay, _ := strconv.ParseBool(r.URL.Query().Get("replay"))
+	p := Playlist{Name: r.URL.Query().Get("name"), Lang: r.URL.Query().Get("lang"), Replay: replay}
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case strings.Contains(r.Header.Get("Content-Type"), "csv"),
+		len(trimmed) > 0 && trimmed[0] != '{' && trimmed[0] != '[':
+		if p.Songs, err = parsePlaylistCSV(bytes.NewReader(trimmed)); err != nil {
+			return Playlist{}, fmt.Errorf("invalid csv: %v", err)
+		}
+	case len(trimmed) > 0 && trimmed[0] == '[':
+		if err := json.Unmarshal(trimmed, &p.Songs); err != nil {
+			return Playlist{}, errors.New("invalid json")
+		}
+	default:
+		var req struct {
+			Name   string `json:"name"`
+			Lang   string `json:"lang"`
+			Replay bool   `json:"replay"`
+			Songs  []Song `json:"songs"`
+		}
+		if err := json.Unmarshal(trimmed, &req); err != nil {
+			return Playlist{}, errors.New("invalid json")
+		}
+		if req.Name != "" {
+			p.Name = req.Name
+		}
+		if req.Lang != "" {
+			p.Lang = req.Lang
+		}
+		p.Replay = p.Replay || req.Replay
+		p.Songs = req.Songs
+	}
+	p.Name = strings.TrimSpace(p.Name)
+	p.Lang = strings.TrimSpace(p.Lang)
+	if p.Lang == "" {
+		return Playlist{}, errors.New("missing lang, the language guesses are matched in")
+	}
+	if p.Songs, err = cleanPlaylistSongs(p.Songs); err != nil {
+		return Playlist{}, err
+	}
+	return p, nil
+}
+
+// savePlaylist gives p an ID, stores it and answers with its summary and
+// where to start playing it. extra is merged into the response.
+func savePlaylist(w http.ResponseWriter, p Playlist, extra map[string]interface{}) {
+	p.ID, p.CreatedAt = randomID(8), time.Now()
+	if p.Name == "" {
+		p.Name = "Playlist " + p.ID
+	}
+	if err := store.PutPlaylist(p); err != nil {
+		http.Error(w, "store error", http.StatusInternalServerError)
+		return
+	}
+	resp := map[string]interface{}{"playlist": p.summary(), "start_url": "/start?playlist=" + p.ID}
+	for k, v := range extra {
+		resp[k] = v
+	}
+	writeJSON(w, resp)
+}
+
+// playlistsHandler stores a playlist on POST, returns one on GET ?id= and
+// lists all playlists on a plain GET.
+func playlistsHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method == http.MethodPost {
+		p, err := readPlaylist(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		savePlaylist(w, p, nil)
+		return
+	}
+	if id := r.URL.Query().Get("id"); id != "" {
+		p, ok := store.GetPlaylist(id)
+		if !ok {
+			http.Error(w, "playlist not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, p)
+		return
+	}
+	playlists := store.Playlists()
+	out := make([]playlistSummary, len(playlists))
+	for i, p := range playlists {
+		out[i] = p.summary()
+	}
+	writeJSON(w, map[string]interface{}{"playlists": out})
+}
+
+// Reasons a YouTube playlist entry is left out of an import.
+const (
+	skipUnavailable = "unavailable"
+	skipBanned      = "banned_keyword"
+	skipDuration    = "duration"
+)
+
+// songFromVideo works out the title and artist of a playlist entry. YouTube
+// Music's track and artist fields win; otherwise "Artist - Title (Official
+// Video) | Extra" titles are split up and the channel stands in for a
+// missing artist.
+func songFromVideo(v VideoInfo) Song {
+	s := Song{Title: v.Track, Artist: v.Artist, YouTube: v.URL}
+	if s.Title == "" {
+		title, _, _ := strings.Cut(v.Title, "|")
+		for _, sep := range []string{" - ", " – ", " — "} {
+			if artist, t, ok := strings.Cut(title, sep); ok {
+				if s.Artist == "" {
+					s.Artist = artist
+				}
+				title = t
+				break
+			}
+		}
+		s.Title = hintTitle(title)
+		if s.Title == "" {
+			s.Title = v.Title
+		}
+	}
+	if s.Artist == "" {
+		s.Artist = strings.TrimSuffix(strings.TrimSuffix(v.Uploader, " - Topic"), "VEVO")
+	}
+	s.Title = strings.TrimSpace(s.Title)
+	s.Artist = strings.TrimSpace(s.Artist)
+	return s
+}
+
+// playlistSongsFromVideos turns the entries of a YouTube playlist into
+// songs, dropping unavailable or untitled videos and ones the game would
+// reject anyway.
+// It returns how many entries were skipped for each reason.
+func playlistSongsFromVideos(videos []VideoInfo) ([]Song, map[string]int) {
+	skipped := map[string]int{}
+	var songs []Song
+	for _, v := range videos {
+		switch {
+		case v.URL == "" || extractYouTubeID(v.URL) == "" || v.Title == "[Deleted video]" || v.Title == "[Private video]":
+			skipped[skipUnavailable]++
+		case isBanned(v.Title, bannedKeywords):
+			skipped[skipBanned]++
+		// same limits as lookupSong: 20s to 8 minutes
+		case v.Duration > 0 && (v.Duration < 20 || v.Duration > 480):
+			skipped[skipDuration]++
+		default:
+			s := songFromVideo(v)
+			if s.Title == "" {
+				skipped[skipUnavailable]++
+				continue
+			}
+			songs = append(songs, s)
+		}
+	}
+	return songs, skipped
+}
+
+// playlistImportHandler imports a YouTube playlist from JSON {url, lang,
+// name, replay} and saves it like an uploaded one. The playlist's own title is
+// used when name is empty.
+func playlistImportHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		URL    string `json:"url"`
+		Lang   string `json:"lang"`
+		Name   string `json:"name"`
+		Replay bool   `json:"replay"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	req.URL, req.Lang = strings.TrimSpace(req.URL), strings.TrimSpace(req.Lang)
+	if !strings.HasPrefix(req.URL, "http://") && !strings.HasPrefix(req.URL, "https://") {
+		http.Error(w, "missing or invalid playlist url", http.StatusBadRequest)
+		return
+	}
+	if req.Lang == "" {
+		http.Error(w, "missing lang, the language guesses are matched in", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+	info, err := media.Playlist(ctx, req.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	songs, skipped := playlistSongsFromVideos(info.Entries)
+	nSkipped := 0
+	for _, n := range skipped {
+		nSkipped += n
+	}
+	truncated := len(songs) > maxPlaylistSongs
+	if truncated {
+		songs = songs[:maxPlaylistSongs]
+	}
+	songs, err = cleanPlaylistSongs(songs)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s: %v (%d of %d entries skipped)", req.URL, err, nSkipped, len(info.Entries)), http.StatusUnprocessableEntity)
+		return
+	}
+	p := Playlist{Name: strings.TrimSpace(req.Name), Lang: req.Lang, Source: req.URL, Replay: req.Replay, Songs: songs}
+	if p.Name == "" {
+		p.Name = info.Title
+	}
+	savePlaylist(w, p, map[string]interface{}{"entries": len(info.Entries), "skipped": skipped, "truncated": truncated})
+}
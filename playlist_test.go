@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPlaylistReplays(t *testing.T) {
+	newTestServer(t, kesariya)
+	p := Playlist{ID: "pl1", Lang: "hindi", Replay: true, Songs: []Song{
+		{Title: "Kesariya", Artist: "Arijit Singh", YouTube: "https://www.youtube.com/watch?v=fake0000001"},
+		{Title: "Chaleya", Artist: "Arijit Singh"},
+	}}
+	if err := store.PutPlaylist(p); err != nil {
+		t.Fatal(err)
+	}
+	q := songQuery{Lang: "hindi", Playlist: p.ID}
+	// the third round wraps around, the fourth comes after a restart that
+	// forgets the cursor but not the used videos
+	want := []string{"Kesariya", "Chaleya", "Kesariya", "Kesariya", "Chaleya"}
+	src := &playlistSource{}
+	for i, title := range want {
+		if i == 3 {
+			src = &playlistSource{}
+		}
+		c, err := src.Next(context.Background(), q)
+		if err != nil {
+			t.Fatalf("round %d: %v", i+1, err)
+		}
+		if c.Title != title {
+			t.Errorf("round %d = %q, want %q", i+1, c.Title, title)
+		}
+	}
+}
+
+func TestParsePlaylistCSV(t *testing.T) {
+	tests := []struct {
+		name, csv string
+	}{
+		{"no header", "Kesariya,Arijit Singh,https://youtu.be/abcdefghijk\nCalm Down,Rema\n"},
+		{"header", "title,artist,youtube\nKesariya,Arijit Singh,https://youtu.be/abcdefghijk\nCalm Down,Rema,\n"},
+		{"reordered header", "URL, Artist, Title\nhttps://youtu.be/abcdefghijk,Arijit Singh,Kesariya\n,Rema,Calm Down\n"},
+	}
+	want := []Song{
+		{Title: "Kesariya", Artist: "Arijit Singh", YouTube: "https://youtu.be/abcdefghijk"},
+		{Title: "Calm Down", Artist: "Rema"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			songs, err := parsePlaylistCSV(strings.NewReader(tt.csv))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(songs) != len(want) {
+				t.Fatalf("got %d songs %+v, want %d", len(songs), songs, len(want))
+			}
+			for i := range want {
+				if songs[i].Title != want[i].Title || songs[i].Artist != want[i].Artist || songs[i].YouTube != want[i].YouTube {
+					t.Errorf("song %d = %+v, want %+v", i, songs[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPlaylistUsesVideosOnce(t *testing.T) {
+	newTestServer(t, kesariya)
+	p := Playlist{ID: "pl1", Lang: "hindi", Songs: []Song{
+		{Title: "Kesariya", Artist: "Arijit Singh", YouTube: "https://www.youtube.com/watch?v=fake0000001"},
+		{Title: "Chaleya", Artist: "Arijit Singh"},
+	}}
+	if err := store.PutPlaylist(p); err != nil {
+		t.Fatal(err)
+	}
+	q := songQuery{Lang: "hindi", Playlist: p.ID}
+	src := &playlistSource{}
+	for i, title := range []string{"Kesariya", "Chaleya"} {
+		c, err := src.Next(context.Background(), q)
+		if err != nil {
+			t.Fatalf("round %d: %v", i+1, err)
+		}
+		if c.Title != title {
+			t.Errorf("round %d = %q, want %q", i+1, c.Title, title)
+		}
+	}
+	if c, err := src.Next(context.Background(), q); err == nil {
+		t.Errorf("third round = %q, want every video used", c.Title)
+	}
+}
+
+func TestPlaylistImportSkipsUntitled(t *testing.T) {
+	srv := newTestServer(t, kesariya)
+	f := &fakeMedia{}
+	f.Add(VideoInfo{ID: "fake0000001", Title: "Kesariya", Uploader: "Arijit Singh", Duration: 180})
+	f.Add(VideoInfo{ID: "fake0000002", Uploader: "Unknown", Duration: 200})
+	f.Add(VideoInfo{ID: "fake0000003", Title: "Rema - Calm Down (Official Video)", Duration: 220})
+	media = f
+
+	resp, err := http.Post(srv.URL+"/playlists/import", "application/json",
+		strings.NewReader(`{"url": "https://www.youtube.com/playlist?list=PLfake", "lang": "english"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("import: %s: %s", resp.Status, b)
+	}
+	var out struct {
+		Playlist playlistSummary `json:"playlist"`
+		Entries  int             `json:"entries"`
+		Skipped  map[string]int  `json:"skipped"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Entries != 3 || out.Playlist.Songs != 2 || out.Skipped[skipUnavailable] != 1 {
+		t.Errorf("import = %+v, want 2 of 3 entries kept and the untitled one skipped", out)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// prefetchConfig controls the background pool of ready-to-play rounds.
+type prefetchConfig struct {
+	Size  int           // ready rounds kept per spec, 0 disables prefetching
+	Idle  time.Duration // pools not used for this long are dropped
+	Pools int           // specs prefetched at the same time, 0 means no limit
+}
+
+// prefetchMinUses is how many times a spec has to be started within Idle
+// before it is prefetched, so one-off specs don't trigger extra downloads.
+const prefetchMinUses = 2
+
+// prefetchConfigFromEnv reads PREFETCH_SIZE, PREFETCH_IDLE and
+// PREFETCH_POOLS.
+func prefetchConfigFromEnv() prefetchConfig {
+	cfg := prefetchConfig{Size: 2, Idle: 10 * time.Minute, Pools: 8}
+	if v, err := strconv.Atoi(os.Getenv("PREFETCH_SIZE")); err == nil && v >= 0 {
+		cfg.Size = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("PREFETCH_POOLS")); err == nil && v >= 0 {
+		cfg.Pools = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("PREFETCH_IDLE")); err == nil && v > 0 {
+		cfg.Idle = v
+	}
+	return cfg
+}
+
+// prefetchPool keeps up to Size fully downloaded and trimmed rounds per
+// roundSpec so /start can hand one out instantly. A spec becomes active once
+// players have asked for it prefetchMinUses times, as long as fewer than
+// Pools specs are active, and is dropped after Idle without use.
+type prefetchPool struct {
+	cfg prefetchConfig
+
+	mu    sync.Mutex
+	specs map[roundSpec]*specPool
+}
+
+type specPool struct {
+	ready    []Round
+	filling  int
+	uses     int
+	active   bool
+	lastUsed time.Time
+}
+
+var prefetch = newPrefetchPool(prefetchConfig{})
+
+func newPrefetchPool(cfg prefetchConfig) *prefetchPool {
+	return &prefetchPool{cfg: cfg, specs: map[roundSpec]*specPool{}}
+}
+
+// Take returns a ready round for spec if one is available and starts
+// refilling the pool in the background either way.
+func (p *prefetchPool) Take(spec roundSpec) (Round, bool) {
+	if p.cfg.Size <= 0 {
+		return Round{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sp, ok := p.specs[spec]
+	if !ok {
+		sp = &specPool{}
+		p.specs[spec] = sp
+	}
+	sp.lastUsed = time.Now()
+	sp.uses++
+	if !sp.active && sp.uses >= prefetchMinUses && (p.cfg.Pools == 0 || p.activeLocked() < p.cfg.Pools) {
+		sp.active = true
+	}
+	defer p.refillLocked(spec, sp)
+	if len(sp.ready) == 0 {
+		return Round{}, false
+	}
+	r := sp.ready[0]
+	sp.ready = sp.ready[1:]
+	// the round starts when it is handed out, not when it was prepared
+	r.CreatedAt = time.Now()
+	r.ReadyAt = r.CreatedAt
+	log.Printf("prefetch: served round %s for %s/%ds (%d left)", r.ID, spec.Lang, spec.ClipLength, len(sp.ready))
+	return r, true
+}
+
+// activeLocked counts the specs being prefetched. p.mu must be held.
+func (p *prefetchPool) activeLocked() int {
+	n := 0
+	for _, sp := range p.specs {
+		if sp.active {
+			n++
+		}
+	}
+	return n
+}
+
+// refillLocked starts workers until ready plus in-flight rounds reach Size.
+// Inactive specs are not filled. p.mu must be held.
+func (p *prefetchPool) refillLocked(spec roundSpec, sp *specPool) {
+	for sp.active && len(sp.ready)+sp.filling < p.cfg.Size {
+		sp.filling++
+		go p.fill(spec, sp)
+	}
+}
+
+// fill prepares one round for spec. Failures are not retried right away;
+// the next Take triggers another attempt.
+func (p *prefetchPool) fill(spec roundSpec, sp *specPool) {
+	r, err := prepareRound(spec)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sp.filling--
+	if err != nil {
+		log.Printf("prefetch: %s/%ds: %v", spec.Lang, spec.ClipLength, err)
+		return
+	}
+	if p.specs[spec] != sp {
+		// pool was dropped while we were downloading
+		removeClipDir(r.ClipDir)
+		return
+	}
+	sp.ready = append(sp.ready, r)
+}
+
+// prepareRound resolves a song and downloads its clip synchronously.
+func prepareRound(spec roundSpec) (Round, error) {
+	r, err := newRound(spec)
+	if err != nil {
+		return Round{}, err
+	}
+	path, err := download10sClip(r.YouTube, r.ClipStart, r.ClipLength, nil)
+	if err != nil {
+		return Round{}, err
+	}
+	r.ClipPath = path
+	r.ClipDir = filepath.Dir(path)
+	r.Ready = true
+	r.Stage = stageReady
+	return r, nil
+}
+
+// Sweep drops pools that have been idle too long and ready rounds older
+// than ttl, returning the bytes reclaimed. It is called by the janitor.
+func (p *prefetchPool) Sweep(now time.Time, ttl time.Duration) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var freed int64
+	for spec, sp := range p.specs {
+		if now.Sub(sp.lastUsed) > p.cfg.Idle {
+			for _, r := range sp.ready {
+				freed += removeClipDir(r.ClipDir)
+			}
+			delete(p.specs, spec)
+			continue
+		}
+		kept := sp.ready[:0]
+		for _, r := range sp.ready {
+			if now.Sub(r.CreatedAt) > ttl {
+				freed += removeClipDir(r.ClipDir)
+				continue
+			}
+			kept = append(kept, r)
+		}
+		sp.ready = kept
+	}
+	return freed
+}
+
+// Bytes returns the disk space used by the pool's clips.
+func (p *prefetchPool) Bytes() int64 {
+	var n int64
+	for _, d := range p.Dirs() {
+		n += dirSize(d)
+	}
+	return n
+}
+
+// Trim drops ready rounds, oldest first, until at least want bytes are
+// freed or the pool is empty, and returns the bytes freed. The janitor
+// calls it before expiring stored rounds to fit the quota, since nobody is
+// playing a prefetched round yet.
+func (p *prefetchPool) Trim(want int64) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var freed int64
+	for freed < want {
+		var oldest *specPool
+		for _, sp := range p.specs {
+			if len(sp.ready) > 0 && (oldest == nil || sp.ready[0].CreatedAt.Before(oldest.ready[0].CreatedAt)) {
+				oldest = sp
+			}
+		}
+		if oldest == nil {
+			break
+		}
+		freed += removeClipDir(oldest.ready[0].ClipDir)
+		oldest.ready = oldest.ready[1:]
+	}
+	return freed
+}
+
+// Dirs lists the clip directories held by the pool so the janitor does not
+// mistake them for orphans.
+func (p *prefetchPool) Dirs() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var dirs []string
+	for _, sp := range p.specs {
+		for _, r := range sp.ready {
+			dirs = append(dirs, r.ClipDir)
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPrefetchOnlyRepeatedSpecs(t *testing.T) {
+	newTestServer(t, kesariya)
+	p := newPrefetchPool(prefetchConfig{Size: 1, Idle: time.Hour, Pools: 1})
+	a, err := normalizeSpec(roundSpec{Lang: "hindi", ClipLength: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := normalizeSpec(roundSpec{Lang: "hindi", ClipLength: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := func(spec roundSpec) (active bool, pending int) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		sp := p.specs[spec]
+		return sp.active, len(sp.ready) + sp.filling
+	}
+
+	p.Take(a)
+	if active, pending := state(a); active || pending != 0 {
+		t.Fatalf("after one start: active %v, %d rounds pending, want nothing prefetched", active, pending)
+	}
+	p.Take(a)
+	if active, pending := state(a); !active || pending != 1 {
+		t.Fatalf("after two starts: active %v, %d rounds pending, want 1", active, pending)
+	}
+	p.Take(b)
+	p.Take(b)
+	if active, pending := state(b); active || pending != 0 {
+		t.Errorf("second spec over the pool limit: active %v, %d rounds pending", active, pending)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for len(p.Dirs()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("prefetched round never became ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if p.Bytes() <= 0 {
+		t.Error("prefetched clip takes no space")
+	}
+	if freed := p.Trim(1); freed <= 0 || len(p.Dirs()) != 0 {
+		t.Errorf("Trim freed %d bytes and left %d clips, want the clip removed", freed, len(p.Dirs()))
+	}
+}
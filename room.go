@@ -0,0 +1,482 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Rooms let several players guess the same clip at the same time. A host
+// creates a room with POST /rooms, everyone (host included) connects to
+// /ws?room=CODE, and the host starts each round. Songs come from the same
+// pipeline as /start and every member is judged and scored on their own copy
+// of the round, exactly like a single player /guess.
+//
+// Client messages: {"type":"start"} and {"type":"reveal"} (host only) and
+// {"type":"guess","guess":"..."}.
+// Server messages: joined, members, round_loading, round_ready, round_failed,
+// guess_result (to the guesser), player_guessed, round_end and error.
+
+const (
+	roomCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	roomCodeLength   = 5
+	roomCountdown    = 3 * time.Second  // between round_ready and start_at
+	roomGuessWindow  = 30 * time.Second // guessing time after the clip ends
+	roomReadyTimeout = 2 * time.Minute
+	roomSendBuffer   = 32
+
+	roomMaxMessage = 4096             // bytes; guesses are capped far below this
+	roomWriteWait  = 10 * time.Second // per message written to a member
+	roomPongWait   = 60 * time.Second // a member silent for this long is dropped
+	roomPingPeriod = roomPongWait * 9 / 10
+)
+
+var upgrader = websocket.Upgrader{
+	// the API is open to any origin, see setCORS
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// roomMessage is the envelope of every websocket message.
+type roomMessage struct {
+	Type  string `json:"type"`
+	Guess string `json:"guess,omitempty"`
+}
+
+type roomMember struct {
+	ID    string
+	Name  string
+	Total int
+
+	conn *websocket.Conn
+	send chan []byte
+}
+
+// roomRound is the round currently played in a room. Each member gets a
+// copy of the stored round to track their own guesses and points.
+type roomRound struct {
+	Index   int
+	Round   Round
+	StartAt time.Time
+	players map[*roomMember]*Round
+	timer   *time.Timer
+}
+
+type room struct {
+	Code      string
+	Spec      roundSpec
+	hostToken string
+
+	mu      sync.Mutex
+	host    *roomMember // nil until someone connects with the host token
+	members map[*roomMember]bool
+	current *roomRound
+	loading bool
+	played  int
+}
+
+// roomRegistry holds the open rooms. Rooms live in memory only and are
+// dropped when the last member leaves.
+type roomRegistry struct {
+	mu    sync.Mutex
+	rooms map[string]*room
+}
+
+var rooms = &roomRegistry{rooms: map[string]*room{}}
+
+func (reg *roomRegistry) create(spec roundSpec) *room {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	code := newRoomCode()
+	for reg.rooms[code] != nil {
+		code = newRoomCode()
+	}
+	rm := &room{Code: code, Spec: spec, hostToken: randomID(16), members: map[*roomMember]bool{}}
+	reg.rooms[code] = rm
+	return rm
+}
+
+func (reg *roomRegistry) get(code string) *room {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.rooms[strings.ToUpper(code)]
+}
+
+func (reg *roomRegistry) remove(rm *room) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.rooms[rm.Code] == rm {
+		delete(reg.rooms, rm.Code)
+	}
+}
+
+// roomRoundInPlay reports whether ri is a room round that is still being
+// played, so its answer must not leak through /reveal or /hint.
+func roomRoundInPlay(ri Round) bool {
+	if ri.Room == "" || !ri.FinishedAt.IsZero() {
+		return false
+	}
+	rm := rooms.get(ri.Room)
+	if rm == nil {
+		return false
+	}
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	return rm.loading || rm.current != nil && rm.current.Round.ID == ri.ID
+}
+
+func newRoomCode() string {
+	b := make([]byte, roomCodeLength)
+	for i := range b {
+		b[i] = roomCodeAlphabet[rand.Intn(len(roomCodeAlphabet))]
+	}
+	return string(b)
+}
+
+// roomsHandler creates a room from JSON {lang, clip_length, segment,
+// difficulty, category, playlist} and returns its code and the host token
+// to connect with.
+func roomsHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST to create a room", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Lang       string `json:"lang"`
+		ClipLength int    `json:"clip_length"`
+		Segment    string `json:"segment"`
+		Difficulty string `json:"difficulty"`
+		Category   string `json:"category"`
+		Playlist   string `json:"playlist"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	spec, err := normalizeSpec(roundSpec{Lang: req.Lang, ClipLength: req.ClipLength, Segment: req.Segment, Difficulty: req.Difficulty, Category: req.Category, Playlist: req.Playlist})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rm := rooms.create(spec)
+	log.Printf("room %s created for %s/%ds", rm.Code, spec.Lang, spec.ClipLength)
+	writeJSON(w, map[string]interface{}{"code": rm.Code, "host_token": rm.hostToken, "ws_url": "/ws?room=" + rm.Code})
+}
+
+// wsHandler upgrades a member's connection: /ws?room=CODE&name=NAME, plus
+// host=TOKEN for the host.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	rm := rooms.get(r.URL.Query().Get("room"))
+	if rm == nil {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("room %s: upgrade: %v", rm.Code, err)
+		return
+	}
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = "player"
+	}
+	m := &roomMember{ID: randomID(6), Name: short(name, 32), conn: conn, send: make(chan []byte, roomSendBuffer)}
+	go m.writeLoop()
+
+	// oversized messages and members that stop answering pings end the
+	// connection, which removes them from the room
+	conn.SetReadLimit(roomMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(roomPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(roomPongWait))
+	})
+
+	rm.join(m, r.URL.Query().Get("host") == rm.hostToken)
+	defer rm.leave(m)
+	for {
+		var msg roomMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		rm.handle(m, msg)
+	}
+}
+
+// writeLoop delivers queued messages to m and pings it every
+// roomPingPeriod so the read deadline notices dead connections.
+func (m *roomMember) writeLoop() {
+	ping := time.NewTicker(roomPingPeriod)
+	defer ping.Stop()
+	defer m.conn.Close()
+	for {
+		select {
+		case b, ok := <-m.send:
+			if !ok {
+				return
+			}
+			m.conn.SetWriteDeadline(time.Now().Add(roomWriteWait))
+			if err := m.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := m.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(roomWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sendLocked queues v for m. A member whose buffer is full is too slow to
+// keep up and gets disconnected. rm.mu must be held.
+func (rm *room) sendLocked(m *roomMember, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("room %s: marshal: %v", rm.Code, err)
+		return
+	}
+	select {
+	case m.send <- b:
+	default:
+		m.conn.Close()
+	}
+}
+
+// broadcastLocked sends v to every member. rm.mu must be held.
+func (rm *room) broadcastLocked(v interface{}) {
+	for m := range rm.members {
+		rm.sendLocked(m, v)
+	}
+}
+
+func (rm *room) membersLocked() []map[string]interface{} {
+	out := []map[string]interface{}{}
+	for m := range rm.members {
+		out = append(out, map[string]interface{}{"id": m.ID, "name": m.Name, "total": m.Total, "host": m == rm.host})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i]["total"].(int) > out[j]["total"].(int) })
+	return out
+}
+
+// join adds m to the room. Only a member with the host token becomes host;
+// members who connect before the host wait without one.
+func (rm *room) join(m *roomMember, host bool) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.members[m] = true
+	if host {
+		rm.host = m
+	}
+	rm.sendLocked(m, map[string]interface{}{"type": "joined", "code": rm.Code, "you": m.ID, "host": m == rm.host, "lang": rm.Spec.Lang, "clip_length": rm.Spec.ClipLength, "difficulty": rm.Spec.Difficulty, "category": rm.Spec.Category, "playlist": rm.Spec.Playlist})
+	rm.broadcastLocked(map[string]interface{}{"type": "members", "members": rm.membersLocked()})
+}
+
+// leave removes m and, if m was the host, hands the host role to another
+// member. Before a token holder has joined there is no host to hand on. The
+// room is closed when it is empty.
+func (rm *room) leave(m *roomMember) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if !rm.members[m] {
+		return
+	}
+	delete(rm.members, m)
+	close(m.send)
+	if len(rm.members) == 0 {
+		if rm.current != nil && rm.current.timer != nil {
+			rm.current.timer.Stop()
+		}
+		rooms.remove(rm)
+		log.Printf("room %s closed", rm.Code)
+		return
+	}
+	if rm.host == m {
+		for other := range rm.members {
+			rm.host = other
+			break
+		}
+	}
+	rm.broadcastLocked(map[string]interface{}{"type": "members", "members": rm.membersLocked()})
+}
+
+func (rm *room) handle(m *roomMember, msg roomMessage) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	switch msg.Type {
+	case "start":
+		if m != rm.host {
+			rm.sendLocked(m, roomError("only the host can start a round"))
+			return
+		}
+		if rm.loading || rm.current != nil {
+			rm.sendLocked(m, roomError("a round is already in progress"))
+			return
+		}
+		rm.loading = true
+		rm.played++
+		rm.broadcastLocked(map[string]interface{}{"type": "round_loading", "index": rm.played})
+		go rm.prepare(rm.played)
+	case "reveal":
+		if m != rm.host {
+			rm.sendLocked(m, roomError("only the host can reveal"))
+			return
+		}
+		if rm.current == nil {
+			rm.sendLocked(m, roomError("no round in progress"))
+			return
+		}
+		rm.endLocked(rm.current)
+	case "guess":
+		rm.guessLocked(m, msg.Guess)
+	default:
+		rm.sendLocked(m, roomError("unknown message type"))
+	}
+}
+
+func roomError(msg string) map[string]interface{} {
+	return map[string]interface{}{"type": "error", "message": msg}
+}
+
+// prepare picks and downloads the next song, then tells every member to
+// start playing at the same moment.
+func (rm *room) prepare(index int) {
+	ri, err := startRound(rm.Spec, func(rr *Round) { rr.Room = rm.Code })
+	if err == nil {
+		ri, err = waitRoundReady(ri.ID, roomReadyTimeout)
+	}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	rm.loading = false
+	if err != nil {
+		log.Printf("room %s: round %d: %v", rm.Code, index, err)
+		rm.broadcastLocked(map[string]interface{}{"type": "round_failed", "index": index, "error": err.Error()})
+		return
+	}
+	if len(rm.members) == 0 {
+		return
+	}
+	startAt := time.Now().Add(roomCountdown)
+	// every member's clock starts when the clip starts playing
+	ri.ReadyAt = startAt
+	rr := &roomRound{Index: index, Round: ri, StartAt: startAt, players: map[*roomMember]*Round{}}
+	rm.current = rr
+	rr.timer = time.AfterFunc(roomCountdown+time.Duration(ri.ClipLength)*time.Second+roomGuessWindow, func() {
+		rm.mu.Lock()
+		defer rm.mu.Unlock()
+		rm.endLocked(rr)
+	})
+	rm.broadcastLocked(map[string]interface{}{
+		"type":        "round_ready",
+		"index":       index,
+		"round_id":    ri.ID,
+		"clip_url":    clipURL(ri.ID),
+		"clip_length": ri.ClipLength,
+		"start_at":    startAt.UnixMilli(),
+		"ends_at":     startAt.Add(time.Duration(ri.ClipLength)*time.Second + roomGuessWindow).UnixMilli(),
+	})
+}
+
+// guessLocked judges a member's guess with the shared matcher and scoring.
+// rm.mu must be held.
+func (rm *room) guessLocked(m *roomMember, guess string) {
+	rr := rm.current
+	if rr == nil {
+		rm.sendLocked(m, roomError("no round in progress"))
+		return
+	}
+	now := time.Now()
+	if now.Before(rr.StartAt) {
+		rm.sendLocked(m, roomError("round has not started yet"))
+		return
+	}
+	p, ok := rr.players[m]
+	if !ok {
+		cp := rr.Round
+		cp.Points, cp.WrongAttempts = 0, 0
+		p = &cp
+		rr.players[m] = p
+	}
+	v := matcherFor(rr.Round).Judge(guess, answerTitles(rr.Round), rr.Round.Artist)
+	gained, b := applyGuess(p, v, now)
+	improved := b != nil
+	m.Total += gained
+	res := map[string]interface{}{"type": "guess_result", "title_correct": v.TitleCorrect, "artist_correct": v.ArtistCorrect, "points": gained, "round_points": p.Points}
+	if !v.TitleCorrect {
+		res["reason"] = v.Reason
+	}
+	rm.sendLocked(m, res)
+	if improved {
+		rm.broadcastLocked(map[string]interface{}{"type": "player_guessed", "id": m.ID, "name": m.Name, "title": p.TitleGuessed, "artist": p.ArtistGuessed})
+	}
+
+	// everyone got the whole answer: no need to wait for the timer
+	for other := range rm.members {
+		if op, ok := rr.players[other]; !ok || !op.TitleGuessed || !op.ArtistGuessed {
+			return
+		}
+	}
+	rm.endLocked(rr)
+}
+
+// endLocked closes rr, reveals the answer and pushes the round's ranking.
+// It is a no-op if rr is no longer the current round. rm.mu must be held.
+func (rm *room) endLocked(rr *roomRound) {
+	if rm.current != rr {
+		return
+	}
+	rm.current = nil
+	rr.timer.Stop()
+
+	best, solved := 0, false
+	for _, p := range rr.players {
+		best = max(best, p.Points)
+		solved = solved || p.TitleGuessed
+	}
+	if _, err := store.UpdateRound(rr.Round.ID, func(r *Round) {
+		r.Revealed = true
+		r.Solved = solved
+		r.Points = best
+		r.FinishedAt = time.Now()
+	}); err != nil {
+		log.Printf("update round %s: %v", rr.Round.ID, err)
+	}
+
+	ranking := []map[string]interface{}{}
+	for m := range rm.members {
+		e := map[string]interface{}{"id": m.ID, "name": m.Name, "points": 0, "total": m.Total, "title": false, "artist": false}
+		if p, ok := rr.players[m]; ok {
+			e["points"], e["title"], e["artist"] = p.Points, p.TitleGuessed, p.ArtistGuessed
+			if !p.FinishedAt.IsZero() {
+				e["seconds"] = p.FinishedAt.Sub(rr.StartAt).Round(time.Millisecond).Seconds()
+			}
+		}
+		ranking = append(ranking, e)
+	}
+	sort.SliceStable(ranking, func(i, j int) bool {
+		if ranking[i]["points"].(int) != ranking[j]["points"].(int) {
+			return ranking[i]["points"].(int) > ranking[j]["points"].(int)
+		}
+		return ranking[i]["total"].(int) > ranking[j]["total"].(int)
+	})
+	ri := rr.Round
+	rm.broadcastLocked(map[string]interface{}{
+		"type":       "round_end",
+		"index":      rr.Index,
+		"title":      ri.Title,
+		"artist":     ri.Artist,
+		"youtube":    ri.YouTube,
+		"youtube_at": youTubeAt(ri.YouTube, ri.ClipStart),
+		"ranking":    ranking,
+		"members":    rm.membersLocked(),
+	})
+}
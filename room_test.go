@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestRoomHostNeedsToken(t *testing.T) {
+	rm := &room{Code: "TEST1", hostToken: "secret", members: map[*roomMember]bool{}}
+	member := func(id string) *roomMember {
+		return &roomMember{ID: id, send: make(chan []byte, roomSendBuffer)}
+	}
+
+	early, creator, late := member("early"), member("creator"), member("late")
+	rm.join(early, false)
+	if rm.host != nil {
+		t.Fatal("first member without the token became host")
+	}
+	rm.join(creator, true)
+	rm.join(late, false)
+	if rm.host != creator {
+		t.Fatalf("host = %v, want the token holder", rm.host)
+	}
+	rm.leave(creator)
+	if rm.host == nil || rm.host == creator {
+		t.Errorf("host = %v after the host left, want another member", rm.host)
+	}
+}
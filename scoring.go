@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// Scoring constants. A perfect answer (title and artist, right after the
+// clip became playable, with the shortest clip, no hints and no misses)
+// is worth basePoints+maxTimeBonus+maxClipBonus.
+const (
+	basePoints         = 1000
+	maxTimeBonus       = 500
+	timeBonusWindow    = 60 * time.Second // time bonus reaches 0 after this
+	maxClipBonus       = 300
+	minBonusClip       = 5  // clips this short or shorter get the full clip bonus
+	maxBonusClip       = 60 // clips this long or longer get none
+	wrongAttemptCost   = 100
+	minPointsOnCorrect = 50 // a correct title is never worth less than this
+)
+
+// scoreBreakdown explains how the points for a round were computed.
+type scoreBreakdown struct {
+	Base        int `json:"base"`
+	TimeBonus   int `json:"time_bonus"`
+	ClipBonus   int `json:"clip_bonus"`
+	HintPenalty int `json:"hint_penalty"`
+	WrongCost   int `json:"wrong_attempts_penalty"`
+	Total       int `json:"total"`
+}
+
+// scoreRound computes the points a round is worth at time at given the
+// credit earned so far (see guessVerdict.Credit). Base, time and clip
+// bonuses are scaled by credit; hints and wrong attempts are subtracted.
+func scoreRound(ri Round, credit float64, at time.Time) scoreBreakdown {
+	if credit <= 0 {
+		return scoreBreakdown{}
+	}
+	var b scoreBreakdown
+
+	started := ri.ReadyAt
+	if started.IsZero() {
+		started = ri.CreatedAt
+	}
+	elapsed := at.Sub(started)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	timeFrac := 1 - float64(elapsed)/float64(timeBonusWindow)
+
+	clip := min(max(ri.ClipLength, minBonusClip), maxBonusClip)
+	clipFrac := float64(maxBonusClip-clip) / float64(maxBonusClip-minBonusClip)
+
+	b.Base = int(math.Round(basePoints * credit))
+	b.TimeBonus = int(math.Round(maxTimeBonus * max(timeFrac, 0) * credit))
+	b.ClipBonus = int(math.Round(maxClipBonus * clipFrac * credit))
+	b.HintPenalty = ri.HintCost
+	b.WrongCost = ri.WrongAttempts * wrongAttemptCost
+
+	b.Total = b.Base + b.TimeBonus + b.ClipBonus - b.HintPenalty - b.WrongCost
+	floor := 0
+	if ri.TitleGuessed {
+		floor = minPointsOnCorrect
+	}
+	b.Total = max(b.Total, floor)
+	return b
+}
+
+// applyGuess records verdict v on rr at time now: wrong attempts, what has
+// been guessed, when the round was solved and the points earned. It returns
+// the points gained and, when the guess added to what was known, the round's
+// new score; b is nil otherwise. A wrong answer to a choice round reveals it.
+func applyGuess(rr *Round, v guessVerdict, now time.Time) (gained int, b *scoreBreakdown) {
+	improved := (v.TitleCorrect && !rr.TitleGuessed) || (v.ArtistCorrect && !rr.ArtistGuessed)
+	if !improved && !rr.TitleGuessed {
+		rr.WrongAttempts++
+		if rr.Mode == modeChoice {
+			// a wrong option gives the answer away
+			rr.Revealed = true
+			rr.FinishedAt = now
+		}
+	}
+	rr.TitleGuessed = rr.TitleGuessed || v.TitleCorrect
+	rr.ArtistGuessed = rr.ArtistGuessed || v.ArtistCorrect
+	if rr.TitleGuessed && !rr.Solved {
+		rr.FinishedAt = now
+	}
+	rr.Solved = rr.TitleGuessed
+	if !improved {
+		return 0, nil
+	}
+	score := scoreRound(*rr, roundCredit(*rr), now)
+	if score.Total > rr.Points {
+		gained = score.Total - rr.Points
+		rr.Points = score.Total
+	}
+	return gained, &score
+}
+
+// roundCredit is the credit a round has earned from everything guessed so
+// far. Multiple choice rounds are easier and earn less.
+func roundCredit(ri Round) float64 {
+	c := guessVerdict{TitleCorrect: ri.TitleGuessed, ArtistCorrect: ri.ArtistGuessed}.Credit()
+	if ri.Mode == modeChoice {
+		c *= choiceCreditFactor
+	}
+	return c
+}
+
+// sessionScore sums the points of every round played in a session.
+func sessionScore(sessionID string) int {
+	if sessionID == "" {
+		return 0
+	}
+	total := 0
+	for _, ri := range store.SessionRounds(sessionID) {
+		total += ri.Points
+	}
+	return total
+}
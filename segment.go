@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math/rand"
+	"net/url"
+	"strconv"
+)
+
+// Clip segments accepted by /start?segment=.
+const (
+	segmentIntro  = "intro"        // from the first second
+	segmentRandom = "random"       // anywhere in the song
+	segmentMiddle = "middle"       // centered in the song
+	segmentChorus = "chorus-guess" // where the first chorus usually lands
+)
+
+func validSegment(s string) bool {
+	switch s {
+	case segmentIntro, segmentRandom, segmentMiddle, segmentChorus:
+		return true
+	}
+	return false
+}
+
+// clipStart returns the ffmpeg start offset in seconds for a segment. When
+// the duration is unknown or too short for the clip it starts at 0.
+func clipStart(segment string, duration, clipLength int) int {
+	maxStart := duration - clipLength
+	if duration <= 0 || maxStart <= 0 {
+		return 0
+	}
+	var start int
+	switch segment {
+	case segmentRandom:
+		start = rand.Intn(maxStart + 1)
+	case segmentMiddle:
+		start = maxStart / 2
+	case segmentChorus:
+		// pop and film songs tend to reach the first chorus about a
+		// third of the way in, after the intro and first verse
+		start = duration * 30 / 100
+	}
+	if start > maxStart {
+		start = maxStart
+	}
+	return start
+}
+
+// youTubeAt adds a t= parameter so the link opens at the clip's offset.
+func youTubeAt(link string, start int) string {
+	if start <= 0 {
+		return link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	q := u.Query()
+	q.Set("t", strconv.Itoa(start)+"s")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
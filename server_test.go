@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixedSource always picks the same song.
+type fixedSource Candidate
+
+func (fixedSource) Name() string { return "fixed" }
+
+func (s fixedSource) Next(ctx context.Context, q songQuery) (Candidate, error) {
+	return Candidate(s), nil
+}
+
+// kesariya is the first video of the fake catalog.
+var kesariya = fixedSource{Title: "Kesariya", Artist: "Arijit Singh", YouTube: "https://www.youtube.com/watch?v=fake0000001", Duration: 180}
+
+// newTestServer runs the game server against the offline fake media backend
+// and an in-memory store, like MEDIA_BACKEND=fake STORE_PATH=memory, with
+// songs from src.
+func newTestServer(t *testing.T, src SongSource) *httptest.Server {
+	t.Helper()
+	t.Setenv("GEMINI_API_KEY", "")
+	oldMedia, oldStore, oldSources, oldPrefetch := media, store, songSources, prefetch
+	media = newFakeMedia()
+	store = newMemoryStore()
+	songSources = src
+	prefetch = newPrefetchPool(prefetchConfig{})
+	srv := httptest.NewServer(newMux())
+	t.Cleanup(func() {
+		srv.Close()
+		// let background downloads finish before the globals are swapped back
+		for _, ri := range store.Rounds() {
+			waitRoundReady(ri.ID, 10*time.Second)
+		}
+		for _, ri := range store.Rounds() {
+			removeClipDir(ri.ClipDir)
+		}
+		media, store, songSources, prefetch = oldMedia, oldStore, oldSources, oldPrefetch
+	})
+	return srv
+}
+
+func getJSON(t *testing.T, u string, v interface{}) {
+	t.Helper()
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("GET %s: %s: %s", u, resp.Status, b)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: %v", u, err)
+	}
+}
+
+func postGuess(t *testing.T, srv *httptest.Server, id, guess string) map[string]interface{} {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"id": id, "guess": guess})
+	resp, err := http.Post(srv.URL+"/guess", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("POST /guess: %s: %s", resp.Status, b)
+	}
+	var out map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// waitReadyEvent follows /events until the round is ready.
+func waitReadyEvent(t *testing.T, srv *httptest.Server, id string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events?id="+url.QueryEscape(id), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var stages []string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		stage, ok := strings.CutPrefix(sc.Text(), "event: ")
+		if !ok {
+			continue
+		}
+		stages = append(stages, stage)
+		switch stage {
+		case stageReady:
+			return
+		case stageFailed:
+			t.Fatalf("round %s failed, stages %v", id, stages)
+		}
+	}
+	t.Fatalf("events ended before the round was ready: %v (stages %v)", sc.Err(), stages)
+}
+
+func TestStartClipGuessOffline(t *testing.T) {
+	srv := newTestServer(t, kesariya)
+
+	var start struct {
+		ID      string `json:"id"`
+		ClipURL string `json:"clip_url"`
+	}
+	getJSON(t, srv.URL+"/start?lang=hindi&clipLength=5", &start)
+	if start.ID == "" || start.ClipURL == "" {
+		t.Fatalf("/start = %+v", start)
+	}
+
+	waitReadyEvent(t, srv, start.ID)
+	var status struct {
+		Ready bool   `json:"ready"`
+		Stage string `json:"stage"`
+	}
+	getJSON(t, srv.URL+"/status?id="+start.ID, &status)
+	if !status.Ready || status.Stage != stageReady {
+		t.Fatalf("/status = %+v, want ready", status)
+	}
+
+	resp, err := http.Get(srv.URL + start.ClipURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clip, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(clip), "RIFF") {
+		t.Fatalf("/clip: %s, %d bytes", resp.Status, len(clip))
+	}
+
+	if g := postGuess(t, srv, start.ID, "Naatu Naatu"); g["correct"] != false {
+		t.Errorf("wrong guess: %v", g)
+	}
+	g := postGuess(t, srv, start.ID, "Kesariya by Arijit Singh")
+	if g["title_correct"] != true || g["artist_correct"] != true {
+		t.Fatalf("right guess: %v", g)
+	}
+	if p, _ := g["points"].(float64); p <= 0 {
+		t.Errorf("right guess earned %v points", g["points"])
+	}
+	ri, _ := store.GetRound(start.ID)
+	if !ri.Solved || ri.WrongAttempts != 1 {
+		t.Errorf("round after guesses = solved %v, wrong attempts %d", ri.Solved, ri.WrongAttempts)
+	}
+}
+
+func TestRevealOtherSession(t *testing.T) {
+	srv := newTestServer(t, kesariya)
+	for _, id := range []string{"alice", "bob"} {
+		store.PutSession(Session{ID: id, CreatedAt: time.Now()})
+	}
+	var start struct {
+		ID string `json:"id"`
+	}
+	getJSON(t, srv.URL+"/start?lang=hindi&session=alice", &start)
+	waitReadyEvent(t, srv, start.ID)
+
+	resp, err := http.Get(srv.URL + "/reveal?session=bob&id=" + start.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("/reveal from another session: %s, want 403", resp.Status)
+	}
+	resp, err = http.Get(srv.URL + "/reveal?id=" + start.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("anonymous /reveal: %s, want 403", resp.Status)
+	}
+	if ri, _ := store.GetRound(start.ID); ri.Revealed {
+		t.Error("round was revealed by another session")
+	}
+	var reveal struct {
+		Title string `json:"title"`
+	}
+	getJSON(t, srv.URL+"/reveal?session=alice&id="+start.ID, &reveal)
+	if reveal.Title != "Kesariya" {
+		t.Errorf("/reveal by the owner = %+v", reveal)
+	}
+}
+
+func TestGuessBeforeReadyOrAfterExpiry(t *testing.T) {
+	srv := newTestServer(t, kesariya)
+	now := time.Now()
+	store.PutRound(Round{ID: "downloading", Lang: "hindi", Title: "Kesariya", Artist: "Arijit Singh", Stage: stageDownloading, Mode: modeChoice, CreatedAt: now})
+	store.PutRound(Round{ID: "expired", Lang: "hindi", Title: "Kesariya", Artist: "Arijit Singh", Stage: stageReady, Expired: true, CreatedAt: now})
+
+	for _, id := range []string{"downloading", "expired"} {
+		body, _ := json.Marshal(map[string]interface{}{"id": id, "guess": "Kesariya", "option": 0})
+		resp, err := http.Post(srv.URL+"/guess", "application/json", strings.NewReader(string(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusConflict {
+			t.Errorf("guess on %s round: %s, want 409", id, resp.Status)
+		}
+		if ri, _ := store.GetRound(id); ri.Points != 0 || ri.TitleGuessed || ri.WrongAttempts != 0 {
+			t.Errorf("%s round was scored: %+v", id, ri)
+		}
+	}
+	// no download is running for the fake round, don't let cleanup wait on it
+	store.UpdateRound("downloading", func(rr *Round) { rr.Error, rr.Stage = "test over", stageFailed })
+}
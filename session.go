@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Session ties a player's rounds together. Everything else in the summary
+// (score, streak, languages) is derived from the rounds it owns.
+type Session struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// sessionSummary is returned by GET /session.
+type sessionSummary struct {
+	Session
+	Score        int            `json:"score"`
+	Streak       int            `json:"streak"`
+	BestStreak   int            `json:"best_streak"`
+	RoundsPlayed int            `json:"rounds_played"`
+	RoundsSolved int            `json:"rounds_solved"`
+	Languages    map[string]int `json:"languages"`
+}
+
+const sessionCookie = "session"
+
+// sessionFromRequest returns the session token sent with r, looking at the
+// session query parameter, the X-Session header and the session cookie.
+func sessionFromRequest(r *http.Request) string {
+	if id := r.URL.Query().Get("session"); id != "" {
+		return id
+	}
+	if id := r.Header.Get("X-Session"); id != "" {
+		return id
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// ownedBy reports whether r may act on something owned by session owner:
+// anything without an owner is open, everything else needs exactly the
+// owner's session, so anonymous requests are refused too.
+func ownedBy(r *http.Request, owner string) bool {
+	return owner == "" || sessionFromRequest(r) == owner
+}
+
+// summarizeSession walks the session's rounds oldest first. Rounds still in
+// progress neither extend nor break the streak.
+func summarizeSession(s Session) sessionSummary {
+	sum := sessionSummary{Session: s, Languages: map[string]int{}}
+	for _, ri := range store.SessionRounds(s.ID) {
+		sum.RoundsPlayed++
+		sum.Score += ri.Points
+		sum.Languages[strings.ToLower(ri.Lang)]++
+		switch {
+		case ri.Solved:
+			sum.RoundsSolved++
+			sum.Streak++
+			sum.BestStreak = max(sum.BestStreak, sum.Streak)
+		case ri.Revealed || ri.Expired || ri.Error != "":
+			sum.Streak = 0
+		}
+	}
+	return sum
+}
+
+// sessionHandler issues a new session on POST (optional JSON {name}) and
+// returns the summary of the caller's session on GET.
+func sessionHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method == http.MethodPost {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid json", http.StatusBadRequest)
+				return
+			}
+		}
+		s := Session{ID: randomID(16), Name: strings.TrimSpace(req.Name), CreatedAt: time.Now()}
+		if err := store.PutSession(s); err != nil {
+			http.Error(w, "store error", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: s.ID, Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode})
+		writeJSON(w, summarizeSession(s))
+		return
+	}
+	id := sessionFromRequest(r)
+	if id == "" {
+		http.Error(w, "missing session", http.StatusBadRequest)
+		return
+	}
+	s, ok := store.GetSession(id)
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, summarizeSession(s))
+}
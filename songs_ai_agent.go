@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -10,11 +11,11 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"google.golang.org/genai"
@@ -28,42 +29,127 @@ func main() {
 }
 
 func run() error {
-	http.HandleFunc("/start", startHandler)
-	http.HandleFunc("/clip", clipHandler)
-	http.HandleFunc("/status", statusHandler)
-	http.HandleFunc("/guess", guessHandler)
-	http.HandleFunc("/reveal", revealHandler)
-	http.HandleFunc("/refreshCache", refreshCacheHandler)
+	order := os.Getenv("SONG_SOURCES")
+	if order == "" {
+		order = defaultSourceOrder
+	}
+	chain, err := newSourceChain(order)
+	if err != nil {
+		return err
+	}
+	songSources = chain
+	log.Printf("song sources: %s", chain.Name())
+
+	mb, err := newMediaBackend(os.Getenv("MEDIA_BACKEND"))
+	if err != nil {
+		return err
+	}
+	media = mb
+
+	st, err := openStore(os.Getenv("STORE_PATH"))
+	if err != nil {
+		return err
+	}
+	store = st
+	defer store.Close()
+
+	matcher = matcherFromEnv()
+	prefetch = newPrefetchPool(prefetchConfigFromEnv())
+	go runJanitor(janitorConfigFromEnv())
+
+	// stop cleanly on Ctrl-C or SIGTERM so the deferred Close writes
+	// pending store changes
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Addr: ":8080", Handler: newMux()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
 
 	fmt.Println("Songs AI game server listening on :8080")
-	return http.ListenAndServe(":8080", nil)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// newMux registers every endpoint of the game server.
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", startHandler)
+	mux.HandleFunc("/clip", clipHandler)
+	mux.HandleFunc("/status", statusHandler)
+	mux.HandleFunc("/guess", guessHandler)
+	mux.HandleFunc("/reveal", revealHandler)
+	mux.HandleFunc("/refreshCache", refreshCacheHandler)
+	mux.HandleFunc("/history", historyHandler)
+	mux.HandleFunc("/janitor", janitorHandler)
+	mux.HandleFunc("/aliases", aliasesHandler)
+	mux.HandleFunc("/session", sessionHandler)
+	mux.HandleFunc("/match", matchHandler)
+	mux.HandleFunc("/match/next", matchNextHandler)
+	mux.HandleFunc("/leaderboard", leaderboardHandler)
+	mux.HandleFunc("/leaderboard/me", leaderboardMeHandler)
+	mux.HandleFunc("/rooms", roomsHandler)
+	mux.HandleFunc("/ws", wsHandler)
+	mux.HandleFunc("/events", eventsHandler)
+	mux.HandleFunc("/hint", hintHandler)
+	mux.HandleFunc("/categories", categoriesHandler)
+	mux.HandleFunc("/playlists", playlistsHandler)
+	mux.HandleFunc("/playlists/import", playlistImportHandler)
+	return mux
 }
 
+// Round is a single song to guess. TitleGuessed and ArtistGuessed record
+// what the player has named so far; Solved means the title was guessed.
+// Points is the score earned so far, counted from ReadyAt.
 type Round struct {
-	ID         string    `json:"id"`
-	Title      string    `json:"title"`
-	Artist     string    `json:"artist"`
-	YouTube    string    `json:"youtube"`
-	ClipPath   string    `json:"-"`
-	Ready      bool      `json:"ready"`
-	Error      string    `json:"-"`
-	ClipLength int       `json:"-"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID            string    `json:"id"`
+	Lang          string    `json:"lang"`
+	Title         string    `json:"title"`
+	Artist        string    `json:"artist"`
+	Aliases       []string  `json:"aliases,omitempty"`
+	YouTube       string    `json:"youtube"`
+	ClipPath      string    `json:"clip_path,omitempty"`
+	ClipDir       string    `json:"clip_dir,omitempty"`
+	Ready         bool      `json:"ready"`
+	Stage         string    `json:"stage,omitempty"`
+	Progress      *Progress `json:"progress,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	ClipLength    int       `json:"clip_length"`
+	Segment       string    `json:"segment,omitempty"`
+	ClipStart     int       `json:"clip_start"`
+	Duration      int       `json:"duration,omitempty"`
+	Mode          string    `json:"mode,omitempty"`
+	Options       []Song    `json:"options,omitempty"` // choice mode only
+	Difficulty    string    `json:"difficulty,omitempty"`
+	Category      string    `json:"category,omitempty"`
+	Playlist      string    `json:"playlist,omitempty"`
+	SessionID     string    `json:"session_id,omitempty"`
+	MatchID       string    `json:"match_id,omitempty"`
+	Room          string    `json:"room,omitempty"`
+	ReadyAt       time.Time `json:"ready_at,omitempty"`
+	WrongAttempts int       `json:"wrong_attempts,omitempty"`
+	HintLevel     int       `json:"hint_level,omitempty"`
+	HintCost      int       `json:"hint_cost,omitempty"`
+	Year          int       `json:"year,omitempty"`
+	Film          string    `json:"film,omitempty"`
+	DetailsLookup bool      `json:"details_lookup,omitempty"` // Gemini was asked for Year and Film
+	Points        int       `json:"points"`
+	Solved        bool      `json:"solved,omitempty"`
+	TitleGuessed  bool      `json:"title_guessed,omitempty"`
+	ArtistGuessed bool      `json:"artist_guessed,omitempty"`
+	Revealed      bool      `json:"revealed,omitempty"`
+	Expired       bool      `json:"expired,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	FinishedAt    time.Time `json:"finished_at,omitempty"`
 }
 
 var (
-	rounds         = map[string]*Round{}
-	roundsMu       sync.Mutex
-	usedMu         sync.Mutex
-	usedVideos     = map[string]struct{}{}
-	rng            = rand.New(rand.NewSource(time.Now().UnixNano()))
 	bannedKeywords = []string{"mix", "compilation", "medley", "playlist", "full album", "full song", "continuous", "best of", "mega mix", "mashup", "various artists", "compilations", "album", "album version", "greatest hits", "popular songs", "top hits"}
-
-	// Song cache from Gemini
-	songCacheMu   sync.Mutex
-	songCache     = []struct{ Title, Artist string }{}
-	songCacheIdx  = 0
-	songCacheLang = ""
 )
 
 func startHandler(w http.ResponseWriter, r *http.Request) {
@@ -71,51 +157,237 @@ func startHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodOptions {
 		return
 	}
-	lang := r.URL.Query().Get("lang")
-	if lang == "" {
-		http.Error(w, "missing lang parameter, e.g. ?lang=english", http.StatusBadRequest)
+	clipLength, _ := strconv.Atoi(r.URL.Query().Get("clipLength"))
+	spec, err := normalizeSpec(roundSpec{
+		Lang:       r.URL.Query().Get("lang"),
+		ClipLength: clipLength,
+		Segment:    r.URL.Query().Get("segment"),
+		Mode:       r.URL.Query().Get("mode"),
+		Difficulty: r.URL.Query().Get("difficulty"),
+		Category:   r.URL.Query().Get("category"),
+		Playlist:   r.URL.Query().Get("playlist"),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	clipLength := 30
-	if cl := r.URL.Query().Get("clipLength"); cl != "" {
-		if parsed, err := strconv.Atoi(cl); err == nil && parsed > 0 && parsed <= 300 {
-			clipLength = parsed
+	session := sessionFromRequest(r)
+	if session != "" {
+		if _, ok := store.GetSession(session); !ok {
+			http.Error(w, "session not found, create one with POST /session", http.StatusNotFound)
+			return
 		}
 	}
 
-	title, artist, yt, err := searchYouTubeForSong(lang)
+	rinfo, err := startRound(spec, func(rr *Round) { rr.SessionID = session })
 	if err != nil {
-		http.Error(w, fmt.Sprintf("search error: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	resp := map[string]interface{}{"id": rinfo.ID, "clip_url": clipURL(rinfo.ID), "ready": rinfo.Ready}
+	if rinfo.Mode == modeChoice {
+		resp["options"] = rinfo.Options
+	}
+	writeJSON(w, resp)
+}
+
+// startRound hands out a prefetched round for spec, or resolves a new song
+// and downloads its clip in the background. setup fills in ownership (session,
+// match...) before the round is stored. The returned round is Ready only
+// when it came from the prefetch pool.
+func startRound(spec roundSpec, setup func(*Round)) (Round, error) {
+	if rinfo, ok := prefetch.Take(spec); ok {
+		setup(&rinfo)
+		if err := store.PutRound(rinfo); err != nil {
+			return Round{}, fmt.Errorf("store error: %v", err)
+		}
+		return rinfo, nil
+	}
+
+	if spec.Mode == modeChoice {
+		// the options are part of the /start response, so the song has to
+		// be known before we return
+		rinfo, err := newRound(spec)
+		if err != nil {
+			return Round{}, fmt.Errorf("search error: %v", err)
+		}
+		rinfo.Stage = stageDownloading
+		setup(&rinfo)
+		if err := store.PutRound(rinfo); err != nil {
+			return Round{}, fmt.Errorf("store error: %v", err)
+		}
+		go downloadRound(rinfo)
+		return rinfo, nil
+	}
+
+	rinfo := Round{ID: randomID(8), Lang: spec.Lang, ClipLength: spec.ClipLength, Segment: spec.Segment, Mode: spec.Mode, Difficulty: spec.Difficulty, Category: spec.Category, Playlist: spec.Playlist, Stage: stageResolving, CreatedAt: time.Now()}
+	setup(&rinfo)
+	if err := store.PutRound(rinfo); err != nil {
+		return Round{}, fmt.Errorf("store error: %v", err)
+	}
 
-	id := randomID(8)
-	rinfo := &Round{ID: id, Title: title, Artist: artist, YouTube: yt, Ready: false, ClipLength: clipLength, CreatedAt: time.Now()}
-	roundsMu.Lock()
-	rounds[id] = rinfo
-	roundsMu.Unlock()
+	// pick the song and download its clip in the background so we return
+	// immediately; /events and /status follow the progress
+	go resolveAndDownload(rinfo.ID, spec)
+	return rinfo, nil
+}
 
-	// download clip in background so we return immediately
-	go func(rid, youtube string, clipLen int) {
-		path, derr := download10sClip(youtube, clipLen)
-		roundsMu.Lock()
-		defer roundsMu.Unlock()
-		rr := rounds[rid]
-		if rr == nil {
+// resolveAndDownload fills in the song of a stored round that is still
+// resolving, then downloads its clip.
+func resolveAndDownload(id string, spec roundSpec) {
+	song, err := newRound(spec)
+	ri, uerr := updateRoundAndNotify(id, func(rr *Round) {
+		if err != nil {
+			rr.Error = fmt.Sprintf("search error: %v", err)
+			rr.Stage = stageFailed
 			return
 		}
+		rr.Title, rr.Artist, rr.Aliases, rr.YouTube = song.Title, song.Artist, song.Aliases, song.YouTube
+		rr.ClipStart, rr.Duration = song.ClipStart, song.Duration
+		rr.Year, rr.Film = song.Year, song.Film
+		rr.Options = song.Options
+		rr.Stage = stageDownloading
+	})
+	if uerr != nil {
+		log.Printf("update round %s: %v", id, uerr)
+		return
+	}
+	if err != nil {
+		log.Printf("round %s: search error: %v", id, err)
+		return
+	}
+	downloadRound(ri)
+}
+
+// roundSpec describes the kind of round a player asked for.
+type roundSpec struct {
+	Lang       string
+	ClipLength int
+	Segment    string
+	Mode       string
+	Difficulty string
+	Category   string
+	Playlist   string
+}
+
+func (s roundSpec) query() songQuery {
+	return songQuery{Lang: s.Lang, Difficulty: s.Difficulty, Category: s.Category, Playlist: s.Playlist}
+}
+
+// Game modes: free text guesses, or picking one of four options.
+const (
+	modeClassic = "classic"
+	modeChoice  = "choice"
+)
+
+func validMode(m string) bool {
+	return m == modeClassic || m == modeChoice
+}
+
+// normalizeSpec fills in defaults (medium difficulty, whose 30s intro clip
+// is the difficulty's default, classic mode) and rejects specs /start cannot
+// serve. Out of range clip lengths fall back to the difficulty's default.
+func normalizeSpec(spec roundSpec) (roundSpec, error) {
+	if spec.Playlist != "" {
+		p, ok := store.GetPlaylist(spec.Playlist)
+		if !ok {
+			return spec, fmt.Errorf("playlist %q not found", spec.Playlist)
+		}
+		if spec.Category != "" {
+			return spec, errors.New("category can't be combined with a playlist")
+		}
+		// playlist rounds are in the playlist's language
+		spec.Lang = p.Lang
+	}
+	if spec.Lang == "" {
+		return spec, errors.New("missing lang parameter, e.g. ?lang=english")
+	}
+	d, ok := parseDifficulty(spec.Difficulty)
+	if !ok {
+		return spec, errors.New("invalid difficulty, use easy, medium, hard or expert")
+	}
+	spec.Difficulty = d
+	if spec.Category != "" {
+		c, ok := lookupCategory(spec.Lang, spec.Category)
+		if !ok {
+			return spec, fmt.Errorf("unknown category %q for %s, see /categories?lang=%s", spec.Category, spec.Lang, url.QueryEscape(spec.Lang))
+		}
+		spec.Category = c.ID
+	}
+	level := difficultyFor(d)
+	if spec.ClipLength <= 0 || spec.ClipLength > 300 {
+		spec.ClipLength = level.ClipLength
+	}
+	if spec.Segment == "" {
+		spec.Segment = level.Segment
+	}
+	if !validSegment(spec.Segment) {
+		return spec, errors.New("invalid segment, use intro, random, middle or chorus-guess")
+	}
+	if spec.Mode == "" {
+		spec.Mode = modeClassic
+	}
+	if !validMode(spec.Mode) {
+		return spec, errors.New("invalid mode, use classic or choice")
+	}
+	return spec, nil
+}
+
+// newRound resolves the next song for spec into a Round without a clip and
+// picks where in the song the clip starts.
+func newRound(spec roundSpec) (Round, error) {
+	c, err := searchYouTubeForSong(spec.query())
+	if err != nil {
+		return Round{}, err
+	}
+	if c.Duration == 0 && spec.Segment != segmentIntro {
+		if d, err := getYouTubeDurationSeconds(c.YouTube); err == nil {
+			c.Duration = d
+		}
+	}
+	var options []Song
+	if spec.Mode == modeChoice {
+		options, err = pickOptions(context.Background(), spec.query(), Song{Title: c.Title, Artist: c.Artist, Aliases: c.Aliases})
+		if err != nil {
+			return Round{}, err
+		}
+	}
+	start := clipStart(spec.Segment, c.Duration, spec.ClipLength)
+	return Round{ID: randomID(8), Lang: spec.Lang, Title: c.Title, Artist: c.Artist, Aliases: c.Aliases, YouTube: c.YouTube, Ready: false, ClipLength: spec.ClipLength, Segment: spec.Segment, Mode: spec.Mode, Difficulty: spec.Difficulty, Category: spec.Category, Playlist: spec.Playlist, Options: options, ClipStart: start, Duration: c.Duration, Year: c.Year, Film: c.Film, CreatedAt: time.Now()}, nil
+}
+
+// downloadRound fetches the clip of a stored round and records the outcome.
+func downloadRound(ri Round) {
+	rid := ri.ID
+	path, derr := download10sClip(ri.YouTube, ri.ClipStart, ri.ClipLength, roundProgressRecorder(rid))
+	expired := false
+	_, err := updateRoundAndNotify(rid, func(rr *Round) {
 		if derr != nil {
 			rr.Error = derr.Error()
 			rr.Ready = false
+			rr.Stage = stageFailed
+		} else if rr.Expired {
+			expired = true
 		} else {
 			rr.ClipPath = path
+			rr.ClipDir = filepath.Dir(path)
 			rr.Ready = true
+			rr.ReadyAt = time.Now()
+			rr.Stage = stageReady
+			rr.Progress = nil
 		}
-	}(id, yt, clipLength)
+	})
+	if err != nil {
+		log.Printf("update round %s: %v", rid, err)
+	}
+	if derr == nil && (expired || err != nil) {
+		removeClipDir(filepath.Dir(path))
+	}
+}
 
-	resp := map[string]string{"id": id, "clip_url": fmt.Sprintf("/clip?id=%s", url.QueryEscape(id))}
-	writeJSON(w, resp)
+func clipURL(id string) string {
+	return fmt.Sprintf("/clip?id=%s", url.QueryEscape(id))
 }
 
 func clipHandler(w http.ResponseWriter, r *http.Request) {
@@ -128,13 +400,15 @@ func clipHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing id", http.StatusBadRequest)
 		return
 	}
-	roundsMu.Lock()
-	ri := rounds[id]
-	roundsMu.Unlock()
-	if ri == nil {
+	ri, ok := store.GetRound(id)
+	if !ok {
 		http.Error(w, "round not found", http.StatusNotFound)
 		return
 	}
+	if ri.Expired {
+		http.Error(w, "round expired", http.StatusGone)
+		return
+	}
 	if !ri.Ready {
 		if ri.Error != "" {
 			http.Error(w, fmt.Sprintf("clip error: %s", ri.Error), http.StatusInternalServerError)
@@ -149,7 +423,7 @@ func clipHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer f.Close()
-	w.Header().Set("Content-Type", "audio/mpeg")
+	w.Header().Set("Content-Type", clipContentType(ri.ClipPath))
 	io.Copy(w, f)
 }
 
@@ -159,34 +433,100 @@ func guessHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req struct {
-		ID    string `json:"id"`
-		Guess string `json:"guess"`
+		ID     string `json:"id"`
+		Guess  string `json:"guess"`
+		Option *int   `json:"option"` // choice mode
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid json", http.StatusBadRequest)
 		return
 	}
-	roundsMu.Lock()
-	ri := rounds[req.ID]
-	roundsMu.Unlock()
-	if ri == nil {
+	ri, found := store.GetRound(req.ID)
+	if !found {
 		http.Error(w, "round not found", http.StatusNotFound)
 		return
 	}
-	// forgiving matching: user guess appears in title/artist OR title/artist appears in guess
-	guessLow := strings.ToLower(strings.TrimSpace(req.Guess))
-	titleLow := strings.ToLower(strings.TrimSpace(ri.Title))
-	artistLow := strings.ToLower(strings.TrimSpace(ri.Artist))
-	ok := false
-	if guessLow != "" {
-		if strings.Contains(titleLow, guessLow) || strings.Contains(artistLow, guessLow) {
-			ok = true
+	if !ownedBy(r, ri.SessionID) {
+		http.Error(w, "round belongs to another session", http.StatusForbidden)
+		return
+	}
+	if ri.Room != "" {
+		http.Error(w, "room rounds are guessed over the room's websocket", http.StatusConflict)
+		return
+	}
+	if ri.Title == "" {
+		http.Error(w, "round has no song yet, follow /events", http.StatusConflict)
+		return
+	}
+	// points are counted from ReadyAt, so nothing can be scored before the
+	// clip exists or after it is gone
+	if ri.Expired {
+		http.Error(w, "round has expired", http.StatusConflict)
+		return
+	}
+	if !ri.Ready {
+		http.Error(w, "clip is not ready yet, follow /events", http.StatusConflict)
+		return
+	}
+	var v guessVerdict
+	if ri.Mode == modeChoice {
+		if ri.Solved || ri.Revealed {
+			http.Error(w, "choice rounds take a single answer", http.StatusConflict)
+			return
+		}
+		if req.Option == nil || *req.Option < 0 || *req.Option >= len(ri.Options) {
+			http.Error(w, fmt.Sprintf("choice rounds take an option index between 0 and %d", len(ri.Options)-1), http.StatusBadRequest)
+			return
+		}
+		right := *req.Option == answerIndex(ri)
+		v = guessVerdict{TitleCorrect: right, ArtistCorrect: right}
+		if !right {
+			v.Reason = reasonWrongOption
 		}
-		if strings.Contains(guessLow, titleLow) || strings.Contains(guessLow, artistLow) {
-			ok = true
+	} else {
+		v = matcherFor(ri).Judge(req.Guess, answerTitles(ri), ri.Artist)
+	}
+	now := time.Now()
+	var gained int
+	var breakdown *scoreBreakdown
+	ri, err := store.UpdateRound(ri.ID, func(rr *Round) {
+		if rr.Revealed || rr.Expired {
+			// the answer is known or the round is over, nothing left to earn
+			return
+		}
+		if rr.Mode == modeChoice && rr.Solved {
+			// one answer per choice round
+			return
 		}
+		gained, breakdown = applyGuess(rr, v, now)
+	})
+	if err != nil {
+		log.Printf("update round %s: %v", req.ID, err)
+	}
+	resp := map[string]interface{}{
+		"correct":        v.TitleCorrect,
+		"title_correct":  v.TitleCorrect,
+		"artist_correct": v.ArtistCorrect,
+		"credit":         roundCredit(ri),
+		"points":         gained,
+		"round_points":   ri.Points,
 	}
-	writeJSON(w, map[string]interface{}{"correct": ok})
+	if !v.TitleCorrect {
+		resp["reason"] = v.Reason
+	}
+	if ri.Mode == modeChoice && (ri.Solved || ri.Revealed) {
+		resp["answer_index"] = answerIndex(ri)
+	}
+	if breakdown != nil {
+		resp["score"] = breakdown
+	}
+	if ri.SessionID != "" {
+		resp["session_score"] = sessionScore(ri.SessionID)
+	}
+	if sc := matchAfterRound(ri); sc != nil {
+		resp["match_scorecard"] = sc
+	}
+	writeJSON(w, resp)
 }
 
 func statusHandler(w http.ResponseWriter, r *http.Request) {
@@ -199,14 +539,12 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing id", http.StatusBadRequest)
 		return
 	}
-	roundsMu.Lock()
-	ri := rounds[id]
-	roundsMu.Unlock()
-	if ri == nil {
+	ri, ok := store.GetRound(id)
+	if !ok {
 		http.Error(w, "round not found", http.StatusNotFound)
 		return
 	}
-	writeJSON(w, map[string]interface{}{"ready": ri.Ready, "error": ri.Error})
+	writeJSON(w, map[string]interface{}{"ready": ri.Ready, "stage": ri.Stage, "progress": ri.Progress, "error": ri.Error, "expired": ri.Expired})
 }
 
 func revealHandler(w http.ResponseWriter, r *http.Request) {
@@ -219,14 +557,79 @@ func revealHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing id", http.StatusBadRequest)
 		return
 	}
-	roundsMu.Lock()
-	ri := rounds[id]
-	roundsMu.Unlock()
-	if ri == nil {
+	ri, ok := store.GetRound(id)
+	if !ok {
 		http.Error(w, "round not found", http.StatusNotFound)
 		return
 	}
-	writeJSON(w, map[string]string{"title": ri.Title, "artist": ri.Artist, "youtube": ri.YouTube})
+	if !ownedBy(r, ri.SessionID) {
+		http.Error(w, "round belongs to another session", http.StatusForbidden)
+		return
+	}
+	if roomRoundInPlay(ri) {
+		http.Error(w, "room rounds are revealed by the room's host", http.StatusConflict)
+		return
+	}
+	if ri.Title == "" {
+		http.Error(w, "round has no song yet, follow /events", http.StatusConflict)
+		return
+	}
+	if !ri.Revealed {
+		updated, err := store.UpdateRound(ri.ID, func(rr *Round) {
+			rr.Revealed = true
+			if rr.FinishedAt.IsZero() {
+				rr.FinishedAt = time.Now()
+			}
+		})
+		if err != nil {
+			log.Printf("update round %s: %v", ri.ID, err)
+		} else {
+			ri = updated
+		}
+	}
+	resp := map[string]interface{}{"title": ri.Title, "artist": ri.Artist, "aliases": answerTitles(ri)[1:], "youtube": ri.YouTube, "youtube_at": youTubeAt(ri.YouTube, ri.ClipStart), "clip_start": ri.ClipStart}
+	if sc := matchAfterRound(ri); sc != nil {
+		resp["match_scorecard"] = sc
+	}
+	writeJSON(w, resp)
+}
+
+// historyHandler lists finished (solved or revealed) rounds, newest first.
+// Optional filters: lang and limit (default 20).
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	setCORS(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	lang := r.URL.Query().Get("lang")
+	limit := 20
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+	all := store.Rounds()
+	out := []map[string]interface{}{}
+	for i := len(all) - 1; i >= 0 && len(out) < limit; i-- {
+		ri := all[i]
+		if !ri.Solved && !ri.Revealed {
+			continue
+		}
+		if lang != "" && !strings.EqualFold(ri.Lang, lang) {
+			continue
+		}
+		out = append(out, map[string]interface{}{
+			"id":          ri.ID,
+			"lang":        ri.Lang,
+			"title":       ri.Title,
+			"artist":      ri.Artist,
+			"youtube":     ri.YouTube,
+			"clip_length": ri.ClipLength,
+			"solved":      ri.Solved,
+			"created_at":  ri.CreatedAt,
+		})
+	}
+	writeJSON(w, out)
 }
 
 func refreshCacheHandler(w http.ResponseWriter, r *http.Request) {
@@ -239,322 +642,42 @@ func refreshCacheHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing lang parameter", http.StatusBadRequest)
 		return
 	}
+	difficulty, ok := parseDifficulty(r.URL.Query().Get("difficulty"))
+	if !ok {
+		http.Error(w, "invalid difficulty, use easy, medium, hard or expert", http.StatusBadRequest)
+		return
+	}
+	q := songQuery{Lang: lang, Difficulty: difficulty}
+	if cat := r.URL.Query().Get("category"); cat != "" {
+		c, ok := lookupCategory(lang, cat)
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown category %q for %s", cat, lang), http.StatusBadRequest)
+			return
+		}
+		q.Category = c.ID
+	}
 
-	log.Printf("Refreshing song cache for language: %s", lang)
+	log.Printf("Refreshing song cache for %s", q.key())
 
 	// Fetch new songs from Gemini
-	songs, err := craftSongList(lang)
+	n, err := geminiSongs.Refresh(q)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to fetch songs: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Update the cache
-	songCacheMu.Lock()
-	songCache = songs
-	songCacheIdx = 0
-	songCacheLang = lang
-	log.Printf("Cache refreshed with %d songs for language: %s", len(songCache), lang)
-	songCacheMu.Unlock()
-
-	writeJSON(w, map[string]interface{}{"status": "cache refreshed", "songs_loaded": len(songs)})
+	writeJSON(w, map[string]interface{}{"status": "cache refreshed", "songs_loaded": n})
 }
 
-func searchYouTubeForSong(lang string) (title, artist, youtubeURL string, err error) {
-	serpKey := os.Getenv("SERPAPI_API_KEY")
-	// try to craft a better query via Gemini if available
-	qstr, _ := craftSearchQuery(lang)
-	if qstr != "" {
-		log.Printf("crafted search query: %s", qstr)
-	}
-	if qstr == "" {
-		qstr = fmt.Sprintf("popular songs in %s YouTube from the last 2 years", lang)
-	}
-	gemKey := os.Getenv("GEMINI_API_KEY")
-	log.Printf("GEMINI_API_KEY present: %v", gemKey != "")
-
-	// Check if we need to refresh the song cache
-	songCacheMu.Lock()
-	needsRefresh := gemKey != "" && (len(songCache) == 0 || songCacheLang != lang)
-	songCacheMu.Unlock()
-
-	if needsRefresh {
-		log.Printf("Refreshing song cache from Gemini for language: %s", lang)
-		if songs, err := craftSongList(lang); err == nil && len(songs) > 0 {
-			songCacheMu.Lock()
-			songCache = songs
-			songCacheIdx = 0
-			songCacheLang = lang
-			log.Printf("Loaded %d songs into cache", len(songCache))
-			songCacheMu.Unlock()
-		} else {
-			log.Printf("Failed to fetch songs from Gemini: %v", err)
-		}
-	}
-
-	// Try to use songs from cache
-	songCacheMu.Lock()
-	if len(songCache) > 0 && songCacheLang == lang {
-		// Try songs starting from current index
-		startIdx := songCacheIdx
-		for i := 0; i < len(songCache); i++ {
-			idx := (startIdx + i) % len(songCache)
-			s := songCache[idx]
-			songCacheIdx = (idx + 1) % len(songCache)
-			songCacheMu.Unlock()
-
-			sq := s.Title
-			if s.Artist != "" {
-				sq = fmt.Sprintf("%s %s", s.Title, s.Artist)
-			}
-			log.Printf("Searching YouTube for cached song: %s", sq)
-
-			// Use yt-dlp to search for this song
-			cmd := exec.Command("yt-dlp", "--no-warnings", "-J", fmt.Sprintf("ytsearch1:%s", sq))
-			out, err := cmd.CombinedOutput()
-			if err != nil {
-				log.Printf("yt-dlp search error for %s: %v", sq, err)
-				songCacheMu.Lock()
-				continue
-			}
-
-			info, err := parseJSONWithRecovery(out)
-			if err != nil {
-				log.Printf("JSON parse error for %s: %v", sq, err)
-				songCacheMu.Lock()
-				continue
-			}
-
-			// Extract video info
-			var videoURL string
-			var duration int
-
-			// Try entries array first
-			if entries, ok := info["entries"].([]interface{}); ok && len(entries) > 0 {
-				if e0, ok := entries[0].(map[string]interface{}); ok {
-					if uu, ok := e0["webpage_url"].(string); ok {
-						videoURL = uu
-					}
-					if d, ok := e0["duration"].(float64); ok {
-						duration = int(d)
-					}
-				}
-			}
-
-			// Fallback to top-level fields
-			if videoURL == "" {
-				if uu, ok := info["webpage_url"].(string); ok {
-					videoURL = uu
-				}
-				if d, ok := info["duration"].(float64); ok {
-					duration = int(d)
-				}
-			}
-
-			// Validate the result
-			if videoURL == "" {
-				log.Printf("No video URL found for %s", sq)
-				songCacheMu.Lock()
-				continue
-			}
-
-			// Check duration - skip if too long (> 8 minutes = 480s) or too short (< 20s)
-			if duration > 0 && (duration < 20 || duration > 480) {
-				log.Printf("Skipping %s - duration %d seconds is out of range", sq, duration)
-				songCacheMu.Lock()
-				continue
-			}
-
-			// Check if banned and not already used
-			if isBanned(s.Title, bannedKeywords) {
-				log.Printf("Skipping %s - title contains banned keywords", sq)
-				songCacheMu.Lock()
-				continue
-			}
-
-			if id := extractYouTubeID(videoURL); id != "" && !isUsed(id) {
-				markUsed(id)
-				log.Printf("Using cached song: %s by %s (cache position %d/%d)", s.Title, s.Artist, idx+1, len(songCache))
-				return s.Title, s.Artist, videoURL, nil
-			}
-
-			songCacheMu.Lock()
-		}
-		songCacheMu.Unlock()
-		log.Printf("No usable songs in cache, will fall back to search")
-	} else {
-		songCacheMu.Unlock()
-		log.Printf("Song cache is empty or language mismatch")
+// searchYouTubeForSong resolves the next song for q using the configured
+// SongSource chain.
+func searchYouTubeForSong(q songQuery) (Candidate, error) {
+	if q.Playlist != "" {
+		// a playlist replaces the source chain, it never falls back to Gemini
+		return playlistSongs.Next(context.Background(), q)
 	}
-
-	if serpKey != "" {
-		q := url.QueryEscape(qstr)
-		api := fmt.Sprintf("https://serpapi.com/search.json?q=%s&engine=google&api_key=%s", q, serpKey)
-		resp, err := http.Get(api)
-		if err != nil {
-			return "", "", "", err
-		}
-		defer resp.Body.Close()
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("SerpAPI response (truncated): %s", short(string(body), 800))
-		var data map[string]interface{}
-		if err = json.Unmarshal(body, &data); err != nil {
-			return "", "", "", err
-		}
-
-		// collect candidates from organic_results and video_results (skip banned titles and already-used videos)
-		type cand struct{ link, title string }
-		var cands []cand
-		if org, ok := data["organic_results"].([]interface{}); ok {
-			for _, it := range org {
-				m, _ := it.(map[string]interface{})
-				titleField := ""
-				if t, ok := m["title"].(string); ok {
-					titleField = t
-				}
-				if link, ok := m["link"].(string); ok && strings.Contains(link, "youtube.com/watch") {
-					if isBanned(titleField, bannedKeywords) {
-						continue
-					}
-					// attempt to check duration and skip videos longer than 8 minutes (480s)
-					if dur, derr := getYouTubeDurationSeconds(link); derr == nil && dur > 0 && dur > 480 {
-						continue
-					}
-					if id := extractYouTubeID(link); id != "" && !isUsed(id) {
-						cands = append(cands, cand{link: link, title: titleField})
-					}
-				}
-			}
-		}
-		if vids, ok := data["video_results"].([]interface{}); ok {
-			for _, it := range vids {
-				m, _ := it.(map[string]interface{})
-				titleField := ""
-				if t, ok := m["title"].(string); ok {
-					titleField = t
-				}
-				if link, ok := m["link"].(string); ok && strings.Contains(link, "youtube.com/watch") {
-					if isBanned(titleField, bannedKeywords) {
-						continue
-					}
-					// attempt to check duration and skip videos longer than 8 minutes (480s)
-					if dur, derr := getYouTubeDurationSeconds(link); derr == nil && dur > 0 && dur > 480 {
-						continue
-					}
-					if id := extractYouTubeID(link); id != "" && !isUsed(id) {
-						cands = append(cands, cand{link: link, title: titleField})
-					}
-				}
-			}
-		}
-		if len(cands) > 0 {
-			idx := rng.Intn(len(cands))
-			youtubeURL = cands[idx].link
-			title = cands[idx].title
-			if id := extractYouTubeID(youtubeURL); id != "" {
-				markUsed(id)
-			}
-		}
-		if youtubeURL == "" {
-			err := fmt.Errorf("no youtube link found")
-			return "", "", "", err
-		}
-
-		// fetch oembed for title/author
-		oembed := fmt.Sprintf("https://www.youtube.com/oembed?url=%s&format=json", url.QueryEscape(youtubeURL))
-		r2, err := http.Get(oembed)
-		if err != nil {
-			return "", "", "", err
-		}
-		defer r2.Body.Close()
-		b2, _ := io.ReadAll(r2.Body)
-		var o map[string]interface{}
-		if err = json.Unmarshal(b2, &o); err == nil {
-			if t, ok := o["title"].(string); ok {
-				title = t
-			}
-			if a, ok := o["author_name"].(string); ok {
-				artist = a
-			}
-		}
-		return title, artist, youtubeURL, nil
-	}
-
-	// If SerpAPI not available, use yt-dlp to search YouTube directly
-	// Requires yt-dlp on PATH. Request multiple results and pick a single-song candidate.
-	cmd := exec.Command("yt-dlp", "--no-warnings", "-J", fmt.Sprintf("ytsearch5:%s", qstr))
-	out, err := cmd.CombinedOutput()
-	log.Printf("yt-dlp search output (truncated): %s", short(string(out), 2000))
-	if err != nil {
-		log.Printf("yt-dlp search error: %v", err)
-		// fallback sample
-		return "Sample Song", "Sample Artist", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", nil
-	}
-	info, err := parseJSONWithRecovery(out)
-	if err != nil {
-		log.Printf("yt-dlp search parse error: %v", err)
-		return "Sample Song", "Sample Artist", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", nil
-	}
-	// prefer entries array
-	if entries, ok := info["entries"].([]interface{}); ok {
-		type cand struct {
-			link, title, uploader string
-			dur                   int
-		}
-		var cands []cand
-		for _, e := range entries {
-			m, _ := e.(map[string]interface{})
-			tstr := ""
-			if t, ok := m["title"].(string); ok {
-				tstr = t
-			}
-			dur := 0
-			if d, ok := m["duration"].(float64); ok {
-				dur = int(d)
-			}
-			if isBanned(tstr, bannedKeywords) {
-				continue
-			}
-			if dur > 0 && (dur < 20 || dur > 480) {
-				continue
-			}
-			u := ""
-			if uu, ok := m["webpage_url"].(string); ok {
-				u = uu
-			}
-			uploader := ""
-			if up, ok := m["uploader"].(string); ok {
-				uploader = up
-			}
-			if id := extractYouTubeID(u); id != "" && !isUsed(id) {
-				cands = append(cands, cand{link: u, title: tstr, uploader: uploader, dur: dur})
-			}
-		}
-		if len(cands) > 0 {
-			idx := rng.Intn(len(cands))
-			youtubeURL = cands[idx].link
-			title = cands[idx].title
-			artist = cands[idx].uploader
-			if id := extractYouTubeID(youtubeURL); id != "" {
-				markUsed(id)
-			}
-			return title, artist, youtubeURL, nil
-		}
-	}
-	// fallback single fields
-	if u, ok := info["webpage_url"].(string); ok && u != "" {
-		youtubeURL = u
-		if t, ok := info["title"].(string); ok {
-			title = t
-		}
-		if a, ok := info["uploader"].(string); ok {
-			artist = a
-		}
-	}
-	if youtubeURL == "" {
-		return "Sample Song", "Sample Artist", "https://www.youtube.com/watch?v=dQw4w9WgXcQ", nil
-	}
-	return title, artist, youtubeURL, nil
+	log.Printf("GEMINI_API_KEY present: %v", os.Getenv("GEMINI_API_KEY") != "")
+	return songSources.Next(context.Background(), q)
 }
 
 // craftSearchQuery uses the Google GenAI SDK to produce a concise search query
@@ -589,8 +712,19 @@ func craftSearchQuery(lang string) (string, error) {
 
 // craftSongList uses the Google GenAI SDK to ask Gemini for a short JSON array
 // of recent/popular songs in the requested language.
-// It returns a slice of {Title, Artist}.
-func craftSongList(lang string) ([]struct{ Title, Artist string }, error) {
+// It returns a slice of Song.
+func craftSongList(q songQuery) ([]Song, error) {
+	lang := q.Lang
+	level := difficultyFor(q.Difficulty)
+	songs, era := level.Songs, level.Era
+	if c, ok := lookupCategory(lang, q.Category); ok && q.Category != "" {
+		if c.Theme != "" {
+			songs += ", specifically " + c.Theme
+		}
+		if c.Era != "" {
+			era = c.Era
+		}
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
 
@@ -601,16 +735,20 @@ func craftSongList(lang string) ([]struct{ Title, Artist string }, error) {
 		return nil, err
 	}
 
-	prompt := fmt.Sprintf(`Provide a JSON array of 10-15 popular and recent songs in the %s language from the last 2 years. 
-For each song, include the title and artist name.
+	prompt := fmt.Sprintf(`Provide a JSON array of 10-15 %s in the %s language %s. 
+For each song, include the title and artist name, plus an "aliases" array with
+other names players commonly use for it (popular name, alternate spellings or
+romanizations). Do not list the film or album as an alias. Use an empty array if
+there are none.
+Also include the release "year" and the "film" (or album) it is from, empty if unknown.
 Return ONLY a valid JSON array like:
-[{"title":"Song Title","artist":"Artist Name"}]
+[{"title":"Song Title","artist":"Artist Name","aliases":["Popular Name"],"year":2023,"film":"Film Name"}]
 
 Requirements:
 - Include only well-known official songs
 - Avoid compilations, covers, remixes, and album uploads
-- Prefer recent releases from the last 2 years
-- One song per entry`, lang)
+- Only songs %s
+- One song per entry`, songs, lang, era, era)
 
 	resp, err := client.Models.GenerateContent(ctx, "gemini-2.5-flash", genai.Text(prompt), nil)
 	if err != nil {
@@ -649,7 +787,7 @@ Requirements:
 	}
 
 	log.Printf("Successfully parsed JSON array with %d entries", len(arr))
-	out := make([]struct{ Title, Artist string }, 0, len(arr))
+	out := make([]Song, 0, len(arr))
 
 	for _, it := range arr {
 		t := ""
@@ -667,8 +805,29 @@ Requirements:
 			a = v
 		}
 
+		film, _ := it["film"].(string)
+		film = strings.TrimSpace(film)
+
+		// the film is a hint, accepting it as the title would give the
+		// answer away
+		var aliases []string
+		if list, ok := it["aliases"].([]interface{}); ok {
+			for _, v := range list {
+				if s, ok := v.(string); ok && strings.TrimSpace(s) != "" && (film == "" || aliasKey(s) != aliasKey(film)) {
+					aliases = append(aliases, strings.TrimSpace(s))
+				}
+			}
+		}
+
+		year := 0
+		switch v := it["year"].(type) {
+		case float64:
+			year = int(v)
+		case string:
+			year, _ = strconv.Atoi(strings.TrimSpace(v))
+		}
 		if t != "" {
-			out = append(out, struct{ Title, Artist string }{Title: t, Artist: a})
+			out = append(out, Song{Title: t, Artist: a, Aliases: aliases, Year: year, Film: film})
 		}
 	}
 
@@ -680,50 +839,48 @@ Requirements:
 	return out, nil
 }
 
-func download10sClip(youtubeURL string, clipLength int) (string, error) {
+// download10sClip downloads the audio of youtubeURL and trims clipLength
+// seconds starting at start. progress, which may be nil, is told about both
+// stages.
+func download10sClip(youtubeURL string, start, clipLength int, progress ProgressFunc) (string, error) {
+	if progress == nil {
+		progress = func(Progress) {}
+	}
+	inStage := func(stage string) ProgressFunc {
+		return func(p Progress) {
+			p.Stage = stage
+			progress(p)
+		}
+	}
+	ctx := context.Background()
 	tmp, err := os.MkdirTemp("", "songclip")
 	if err != nil {
 		return "", err
 	}
-	log.Printf("downloading audio for %s into %s", youtubeURL, tmp)
-	// download best audio using yt-dlp
-	// prefer to suppress warnings which can leak into output
-	cmd := exec.Command("yt-dlp", "--no-warnings", "-f", "bestaudio", "-o", "%(id)s.%(ext)s", youtubeURL)
-	cmd.Dir = tmp
-	if out, err := cmd.CombinedOutput(); err != nil {
-		log.Printf("yt-dlp download error: %v", err)
-		log.Printf("yt-dlp download output (truncated): %s", short(string(out), 800))
-		return "", fmt.Errorf("yt-dlp error: %v - %s", err, string(out))
-	} else {
-		log.Printf("yt-dlp download output (truncated): %s", short(string(out), 800))
-	}
-	// find downloaded file
-	files, _ := os.ReadDir(tmp)
-	if len(files) == 0 {
-		return "", fmt.Errorf("no file downloaded")
+	progress(Progress{Stage: stageDownloading})
+	inFile, err := media.Download(ctx, youtubeURL, tmp, inStage(stageDownloading))
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", err
 	}
-	var inFile string
-	for _, f := range files {
-		if !f.IsDir() {
-			inFile = filepath.Join(tmp, f.Name())
-			break
-		}
+	progress(Progress{Stage: stageTrimming})
+	clip, err := media.Trim(ctx, inFile, tmp, start, clipLength, inStage(stageTrimming))
+	if err != nil {
+		os.RemoveAll(tmp)
+		return "", err
 	}
-	if inFile == "" {
-		return "", fmt.Errorf("no input file")
+	// only the clip is served, drop the full download right away
+	if err := os.Remove(inFile); err != nil {
+		log.Printf("remove %s: %v", inFile, err)
 	}
+	return clip, nil
+}
 
-	outPath := filepath.Join(tmp, "clip.mp3")
-	// trim to specified length (in seconds)
-	cmd2 := exec.Command("ffmpeg", "-y", "-i", inFile, "-ss", "0", "-t", fmt.Sprintf("%d", clipLength), "-acodec", "libmp3lame", outPath)
-	if out, err := cmd2.CombinedOutput(); err != nil {
-		log.Printf("ffmpeg error: %v", err)
-		log.Printf("ffmpeg output (truncated): %s", short(string(out), 800))
-		return "", fmt.Errorf("ffmpeg error: %v - %s", err, string(out))
-	} else {
-		log.Printf("ffmpeg output (truncated): %s", short(string(out), 800))
+func clipContentType(path string) string {
+	if strings.HasSuffix(path, ".wav") {
+		return "audio/wav"
 	}
-	return outPath, nil
+	return "audio/mpeg"
 }
 
 func writeJSON(w http.ResponseWriter, v interface{}) {
@@ -735,7 +892,7 @@ func writeJSON(w http.ResponseWriter, v interface{}) {
 func setCORS(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Admin-Token, X-Session")
 }
 
 func short(s string, n int) string {
@@ -810,59 +967,46 @@ func parseJSONWithRecovery(data []byte) (map[string]interface{}, error) {
 	return nil, fmt.Errorf("could not parse JSON")
 }
 
-// getYouTubeDurationSeconds tries to fetch video metadata via yt-dlp and
-// return the duration in seconds. If it cannot determine duration it
-// returns an error. Callers may choose to treat unknown duration as keep.
+// getYouTubeDurationSeconds tries to fetch video metadata via the media
+// backend and return the duration in seconds. If it cannot determine duration
+// it returns an error. Callers may choose to treat unknown duration as keep.
 func getYouTubeDurationSeconds(link string) (int, error) {
 	if link == "" {
 		return 0, fmt.Errorf("empty link")
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, "yt-dlp", "--no-warnings", "-J", link)
-	out, err := cmd.CombinedOutput()
+	info, err := media.Probe(ctx, link)
 	if err != nil {
 		return 0, err
 	}
-	info, err := parseJSONWithRecovery(out)
-	if err != nil {
-		return 0, err
-	}
-	if d, ok := info["duration"].(float64); ok {
-		return int(d), nil
-	}
-	if d, ok := info["duration_seconds"].(float64); ok {
-		return int(d), nil
+	if info.Duration == 0 {
+		return 0, fmt.Errorf("duration not found")
 	}
-	if d, ok := info["length"].(float64); ok {
-		return int(d), nil
-	}
-	return 0, fmt.Errorf("duration not found")
+	return info.Duration, nil
 }
+
 func isUsed(id string) bool {
 	if id == "" {
 		return false
 	}
-	usedMu.Lock()
-	defer usedMu.Unlock()
-	_, ok := usedVideos[id]
-	return ok
+	return store.IsUsed(id)
 }
 
 func markUsed(id string) {
 	if id == "" {
 		return
 	}
-	usedMu.Lock()
-	usedVideos[id] = struct{}{}
-	usedMu.Unlock()
+	if err := store.MarkUsed(id); err != nil {
+		log.Printf("mark used %s: %v", id, err)
+	}
 }
 
 func randomID(n int) string {
 	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
 	b := make([]byte, n)
 	for i := range b {
-		b[i] = letters[rng.Intn(len(letters))]
+		b[i] = letters[rand.Intn(len(letters))]
 	}
 	return string(b)
 }
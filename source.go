@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Song is a single title/artist pair as returned by Gemini or uploaded in a
+// playlist. Aliases are other names that count as the title when guessing.
+type Song struct {
+	Title   string   `json:"title"`
+	Artist  string   `json:"artist"`
+	YouTube string   `json:"youtube,omitempty"` // known video, skips the search
+	Aliases []string `json:"aliases,omitempty"`
+	Year    int      `json:"year,omitempty"`
+	Film    string   `json:"film,omitempty"` // film or album, used for hints
+}
+
+// Candidate is a song that has been resolved to a playable YouTube video.
+type Candidate struct {
+	Title    string
+	Artist   string
+	Aliases  []string
+	YouTube  string
+	Duration int
+	Year     int
+	Film     string
+}
+
+// songQuery describes the songs a round wants: a language at a difficulty,
+// optionally themed by a category, or the songs of an uploaded playlist.
+type songQuery struct {
+	Lang       string
+	Difficulty string // empty means medium
+	Category   string // category ID, empty for none
+	Playlist   string // playlist ID, empty for Gemini's songs
+}
+
+// key identifies the Gemini song list for q: "hindi", "hindi|expert",
+// "hindi/90s" or "hindi|expert/90s". Medium lists keep the plain language
+// key they had before difficulties existed. Playlists are keyed by ID alone.
+func (q songQuery) key() string {
+	if q.Playlist != "" {
+		return "playlist:" + q.Playlist
+	}
+	k := cacheKey(q.Lang)
+	if q.Difficulty != "" && q.Difficulty != difficultyMedium {
+		k += "|" + q.Difficulty
+	}
+	if q.Category != "" {
+		k += "/" + q.Category
+	}
+	return k
+}
+
+// SongSource produces the next playable song for a query. Implementations
+// are expected to skip banned and already used videos themselves.
+type SongSource interface {
+	Name() string
+	Next(ctx context.Context, q songQuery) (Candidate, error)
+}
+
+// errSourceUnavailable is returned by sources that are not configured
+// (e.g. a missing API key) so the chain can move on quietly.
+var errSourceUnavailable = errors.New("source not configured")
+
+// sourceChain tries each source in order and returns the first candidate.
+type sourceChain []SongSource
+
+func (c sourceChain) Name() string {
+	names := make([]string, len(c))
+	for i, s := range c {
+		names[i] = s.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (c sourceChain) Next(ctx context.Context, q songQuery) (Candidate, error) {
+	var errs []string
+	for _, s := range c {
+		cand, err := s.Next(ctx, q)
+		if err == nil {
+			return cand, nil
+		}
+		if errors.Is(err, errSourceUnavailable) {
+			log.Printf("song source %s skipped: %v", s.Name(), err)
+		} else {
+			log.Printf("song source %s failed: %v", s.Name(), err)
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", s.Name(), err))
+	}
+	if len(errs) == 0 {
+		return Candidate{}, fmt.Errorf("no song sources configured")
+	}
+	return Candidate{}, fmt.Errorf("no song found (%s)", strings.Join(errs, "; "))
+}
+
+// defaultSourceOrder is used when SONG_SOURCES is not set.
+const defaultSourceOrder = "gemini,serpapi,ytsearch,sample"
+
+// sourceFactories maps the names accepted in SONG_SOURCES to constructors.
+// Register new sources here to make them available to the chain.
+var sourceFactories = map[string]func() SongSource{
+	"gemini":   func() SongSource { return geminiSongs },
+	"serpapi":  func() SongSource { return newSerpAPISource() },
+	"ytsearch": func() SongSource { return &ytSearchSource{query: searchQueryFor} },
+	"sample":   func() SongSource { return sampleSource{} },
+}
+
+// newSourceChain builds a chain from a comma separated list of source names,
+// e.g. "gemini,ytsearch". Unknown names are reported as an error.
+func newSourceChain(order string) (sourceChain, error) {
+	var chain sourceChain
+	for _, name := range strings.Split(order, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		f, ok := sourceFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown song source %q", name)
+		}
+		chain = append(chain, f())
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no song sources in %q", order)
+	}
+	return chain, nil
+}
+
+var (
+	// geminiSongs is shared so /refreshCache can reload the same cache
+	// the chain reads from.
+	geminiSongs = &geminiCacheSource{fetch: craftSongList}
+
+	// songSources is the chain used by searchYouTubeForSong. It is set up
+	// in run from SONG_SOURCES.
+	songSources SongSource = sourceChain{geminiSongs}
+)
+
+// searchQueryFor asks Gemini for a search query and falls back to a generic
+// one. Category queries are simple enough to build directly.
+func searchQueryFor(q songQuery) string {
+	lang := q.Lang
+	if c, ok := lookupCategory(lang, q.Category); ok && q.Category != "" {
+		return fmt.Sprintf("best %s %s songs", lang, c.Name)
+	}
+	qstr, _ := craftSearchQuery(lang)
+	if qstr != "" {
+		log.Printf("crafted search query: %s", qstr)
+		return qstr
+	}
+	return fmt.Sprintf("popular songs in %s YouTube from the last 2 years", lang)
+}
+
+// geminiCacheSource keeps an independent batch of Gemini songs per language
+// and difficulty and resolves them one at a time via yt-dlp. Lists are saved
+// in the store so a restart does not call Gemini again; /refreshCache forces
+// a new list.
+type geminiCacheSource struct {
+	fetch func(q songQuery) ([]Song, error)
+
+	mu     sync.Mutex
+	caches map[string]*songCache
+}
+
+// songCache is the song list and cursor of a single query. refreshMu
+// makes concurrent players share one Gemini call instead of racing.
+type songCache struct {
+	key   string
+	query songQuery
+	// replay lets songs repeat: the cursor wraps around instead of the
+	// list running out once every video has been used.
+	replay bool
+
+	refreshMu sync.Mutex
+
+	mu    sync.Mutex
+	songs []Song
+	idx   int
+	gen   int // bumped on every reload
+}
+
+func (g *geminiCacheSource) Name() string { return "gemini" }
+
+// cacheKey normalizes a language name so "Hindi" and "hindi" share a cache.
+func cacheKey(lang string) string {
+	return strings.ToLower(strings.TrimSpace(lang))
+}
+
+// cache returns the cache for q, creating it on first use.
+func (g *geminiCacheSource) cache(q songQuery) *songCache {
+	key := q.key()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.caches == nil {
+		g.caches = map[string]*songCache{}
+	}
+	c, ok := g.caches[key]
+	if !ok {
+		c = &songCache{key: key, query: q}
+		g.caches[key] = c
+	}
+	return c
+}
+
+// Refresh replaces the cache for q with a fresh list from Gemini.
+func (g *geminiCacheSource) Refresh(q songQuery) (int, error) {
+	c := g.cache(q)
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	return g.refreshLocked(c)
+}
+
+// refreshLocked fetches a new list for c. c.refreshMu must be held.
+func (g *geminiCacheSource) refreshLocked(c *songCache) (int, error) {
+	songs, err := g.fetch(c.query)
+	if err != nil {
+		return 0, err
+	}
+	if len(songs) == 0 {
+		return 0, fmt.Errorf("no songs returned")
+	}
+	c.load(songs)
+	if err := store.PutSongList(c.key, songs); err != nil {
+		log.Printf("save song list for %s: %v", c.key, err)
+	}
+	log.Printf("Loaded %d songs into cache for %s", len(songs), c.key)
+	return len(songs), nil
+}
+
+// ensureLoaded fills an empty cache from the store or, failing that, Gemini.
+func (g *geminiCacheSource) ensureLoaded(c *songCache) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	if c.size() > 0 {
+		// another player loaded it while we waited
+		return nil
+	}
+	if songs, ok := store.SongList(c.key); ok && len(songs) > 0 {
+		log.Printf("Using %d stored songs for %s", len(songs), c.key)
+		c.load(songs)
+		return nil
+	}
+	log.Printf("Refreshing song cache from Gemini for %s", c.key)
+	_, err := g.refreshLocked(c)
+	return err
+}
+
+func (c *songCache) load(songs []Song) {
+	c.mu.Lock()
+	c.songs = songs
+	c.idx = 0
+	c.gen++
+	c.mu.Unlock()
+}
+
+func (c *songCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.songs)
+}
+
+func (g *geminiCacheSource) Next(ctx context.Context, q songQuery) (Candidate, error) {
+	if os.Getenv("GEMINI_API_KEY") == "" {
+		return Candidate{}, errSourceUnavailable
+	}
+	c := g.cache(q)
+	if err := g.ensureLoaded(c); err != nil {
+		return Candidate{}, fmt.Errorf("refresh cache: %v", err)
+	}
+	cand, err := c.next(ctx)
+	if err == nil {
+		return cand, nil
+	}
+	if ctx.Err() != nil {
+		return Candidate{}, err
+	}
+	// every song in the batch has been played or rejected, fetch the next batch
+	log.Printf("Song cache for %s exhausted, fetching a new batch", c.key)
+	if _, rerr := g.Refresh(q); rerr != nil {
+		return Candidate{}, fmt.Errorf("%v; refresh cache: %v", err, rerr)
+	}
+	return c.next(ctx)
+}
+
+// next walks the list from the cursor and returns the first song that
+// resolves to a usable video.
+func (c *songCache) next(ctx context.Context) (Candidate, error) {
+	c.mu.Lock()
+	startIdx, n, gen := c.idx, len(c.songs), c.gen
+	for i := 0; i < n; i++ {
+		idx := (startIdx + i) % n
+		s := c.songs[idx]
+		c.idx = (idx + 1) % n
+		c.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return Candidate{}, ctx.Err()
+		}
+		cand, err := lookupSong(ctx, s, c.replay)
+		if err == nil {
+			log.Printf("Using cached song: %s by %s (%s cache position %d/%d)", s.Title, s.Artist, c.key, idx+1, n)
+			return cand, nil
+		}
+		log.Printf("Skipping cached song %s: %v", s.Title, err)
+
+		c.mu.Lock()
+		if c.gen != gen {
+			// cache was reloaded underneath us
+			break
+		}
+	}
+	c.mu.Unlock()
+	return Candidate{}, fmt.Errorf("no usable songs in %s cache", c.key)
+}
+
+// lookupSong searches YouTube for a known title/artist, or probes its video
+// when the song already has one, and validates the video against the
+// duration, banned keyword and used video rules. With replay a video that
+// has been played before is accepted again.
+func lookupSong(ctx context.Context, s Song, replay bool) (Candidate, error) {
+	var v VideoInfo
+	if s.YouTube != "" {
+		info, err := media.Probe(ctx, s.YouTube)
+		if err != nil {
+			return Candidate{}, err
+		}
+		v = info
+	} else {
+		sq := s.Title
+		if s.Artist != "" {
+			sq = fmt.Sprintf("%s %s", s.Title, s.Artist)
+		}
+		log.Printf("Searching YouTube for cached song: %s", sq)
+
+		results, err := media.Search(ctx, sq, 1)
+		if err != nil {
+			return Candidate{}, err
+		}
+		if len(results) == 0 || results[0].URL == "" {
+			return Candidate{}, fmt.Errorf("no video URL found")
+		}
+		v = results[0]
+	}
+	// Check duration - skip if too long (> 8 minutes = 480s) or too short (< 20s)
+	if v.Duration > 0 && (v.Duration < 20 || v.Duration > 480) {
+		return Candidate{}, fmt.Errorf("duration %d seconds is out of range", v.Duration)
+	}
+	if isBanned(s.Title, bannedKeywords) {
+		return Candidate{}, fmt.Errorf("title contains banned keywords")
+	}
+	id := extractYouTubeID(v.URL)
+	if id == "" || !replay && isUsed(id) {
+		return Candidate{}, fmt.Errorf("video already used")
+	}
+	markUsed(id)
+	return Candidate{Title: s.Title, Artist: s.Artist, Aliases: s.Aliases, YouTube: v.URL, Duration: v.Duration, Year: s.Year, Film: s.Film}, nil
+}
+
+// serpAPISource searches Google via SerpAPI for YouTube links and uses
+// oEmbed to fill in the title and channel name. baseURL, oembedURL and
+// client can be pointed at a test server.
+type serpAPISource struct {
+	query     func(q songQuery) string
+	baseURL   string
+	oembedURL string
+	client    *http.Client
+}
+
+func newSerpAPISource() *serpAPISource {
+	return &serpAPISource{
+		query:     searchQueryFor,
+		baseURL:   "https://serpapi.com",
+		oembedURL: "https://www.youtube.com/oembed",
+		client:    http.DefaultClient,
+	}
+}
+
+func (s *serpAPISource) Name() string { return "serpapi" }
+
+func (s *serpAPISource) Next(ctx context.Context, sq songQuery) (Candidate, error) {
+	serpKey := os.Getenv("SERPAPI_API_KEY")
+	if serpKey == "" {
+		return Candidate{}, errSourceUnavailable
+	}
+	q := url.QueryEscape(s.query(sq))
+	api := fmt.Sprintf("%s/search.json?q=%s&engine=google&api_key=%s", s.baseURL, q, url.QueryEscape(serpKey))
+	body, err := httpGetBody(ctx, s.client, api)
+	if err != nil {
+		return Candidate{}, err
+	}
+	log.Printf("SerpAPI response (truncated): %s", short(string(body), 800))
+	var data map[string]interface{}
+	if err = json.Unmarshal(body, &data); err != nil {
+		return Candidate{}, err
+	}
+
+	// collect candidates from organic_results and video_results (skip banned titles and already-used videos)
+	type cand struct{ link, title string }
+	var cands []cand
+	for _, key := range []string{"organic_results", "video_results"} {
+		results, _ := data[key].([]interface{})
+		for _, it := range results {
+			m, _ := it.(map[string]interface{})
+			titleField := ""
+			if t, ok := m["title"].(string); ok {
+				titleField = t
+			}
+			if link, ok := m["link"].(string); ok && strings.Contains(link, "youtube.com/watch") {
+				if isBanned(titleField, bannedKeywords) {
+					continue
+				}
+				// attempt to check duration and skip videos longer than 8 minutes (480s)
+				if dur, derr := getYouTubeDurationSeconds(link); derr == nil && dur > 0 && dur > 480 {
+					continue
+				}
+				if id := extractYouTubeID(link); id != "" && !isUsed(id) {
+					cands = append(cands, cand{link: link, title: titleField})
+				}
+			}
+		}
+	}
+	if len(cands) == 0 {
+		return Candidate{}, fmt.Errorf("no youtube link found")
+	}
+	c := cands[rand.Intn(len(cands))]
+	markUsed(extractYouTubeID(c.link))
+	out := Candidate{Title: c.title, YouTube: c.link}
+
+	// fetch oembed for title/author
+	oembed := fmt.Sprintf("%s?url=%s&format=json", s.oembedURL, url.QueryEscape(c.link))
+	b2, err := httpGetBody(ctx, s.client, oembed)
+	if err != nil {
+		return Candidate{}, err
+	}
+	var o map[string]interface{}
+	if err = json.Unmarshal(b2, &o); err == nil {
+		if t, ok := o["title"].(string); ok {
+			out.Title = t
+		}
+		if a, ok := o["author_name"].(string); ok {
+			out.Artist = a
+		}
+	}
+	return out, nil
+}
+
+// ytSearchSource uses the media backend's search to find a few results directly on
+// YouTube and picks a random single-song candidate.
+type ytSearchSource struct {
+	query func(q songQuery) string
+}
+
+func (s *ytSearchSource) Name() string { return "ytsearch" }
+
+func (s *ytSearchSource) Next(ctx context.Context, q songQuery) (Candidate, error) {
+	// Request multiple results and pick a single-song candidate.
+	results, err := media.Search(ctx, s.query(q), 5)
+	if err != nil {
+		return Candidate{}, err
+	}
+	var cands []Candidate
+	for _, v := range results {
+		if isBanned(v.Title, bannedKeywords) {
+			continue
+		}
+		if v.Duration > 0 && (v.Duration < 20 || v.Duration > 480) {
+			continue
+		}
+		if id := extractYouTubeID(v.URL); id != "" && !isUsed(id) {
+			cands = append(cands, Candidate{Title: v.Title, Artist: v.Uploader, YouTube: v.URL, Duration: v.Duration})
+		}
+	}
+	if len(cands) == 0 {
+		return Candidate{}, fmt.Errorf("no usable search results")
+	}
+	c := cands[rand.Intn(len(cands))]
+	markUsed(extractYouTubeID(c.YouTube))
+	return c, nil
+}
+
+// sampleSource always returns the same well-known video so the game keeps
+// working when every other source fails.
+type sampleSource struct{}
+
+func (sampleSource) Name() string { return "sample" }
+
+func (sampleSource) Next(ctx context.Context, q songQuery) (Candidate, error) {
+	return Candidate{Title: "Sample Song", Artist: "Sample Artist", YouTube: "https://www.youtube.com/watch?v=dQw4w9WgXcQ"}, nil
+}
+
+func httpGetBody(ctx context.Context, client *http.Client, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
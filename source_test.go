@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// stubSource returns its candidate, or err when set, and counts the calls.
+type stubSource struct {
+	name  string
+	cand  Candidate
+	err   error
+	calls int
+}
+
+func (s *stubSource) Name() string { return s.name }
+
+func (s *stubSource) Next(ctx context.Context, q songQuery) (Candidate, error) {
+	s.calls++
+	return s.cand, s.err
+}
+
+func TestSourceChainFallsThrough(t *testing.T) {
+	off := &stubSource{name: "off", err: errSourceUnavailable}
+	broken := &stubSource{name: "broken", err: errors.New("quota exceeded")}
+	first := &stubSource{name: "first", cand: Candidate{Title: "Kesariya"}}
+	second := &stubSource{name: "second", cand: Candidate{Title: "Chaleya"}}
+	chain := sourceChain{off, broken, first, second}
+
+	if got := chain.Name(); got != "off,broken,first,second" {
+		t.Errorf("Name() = %q", got)
+	}
+	c, err := chain.Next(context.Background(), songQuery{Lang: "hindi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Title != "Kesariya" {
+		t.Errorf("got %q, want the first working source's song", c.Title)
+	}
+	if off.calls != 1 || broken.calls != 1 || first.calls != 1 || second.calls != 0 {
+		t.Errorf("calls = %d,%d,%d,%d, want 1,1,1,0", off.calls, broken.calls, first.calls, second.calls)
+	}
+}
+
+func TestSourceChainAllFail(t *testing.T) {
+	chain := sourceChain{
+		&stubSource{name: "off", err: errSourceUnavailable},
+		&stubSource{name: "broken", err: errors.New("quota exceeded")},
+	}
+	_, err := chain.Next(context.Background(), songQuery{Lang: "hindi"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"off: " + errSourceUnavailable.Error(), "broken: quota exceeded"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	if _, err := (sourceChain{}).Next(context.Background(), songQuery{}); err == nil {
+		t.Error("empty chain returned a song")
+	}
+}
+
+func TestNewSourceChain(t *testing.T) {
+	tests := []struct {
+		order, want string // want is empty when the order is rejected
+	}{
+		{defaultSourceOrder, defaultSourceOrder},
+		{" Gemini, YTSEARCH ,", "gemini,ytsearch"},
+		{"sample", "sample"},
+		{"gemini,spotify", ""},
+		{"", ""},
+		{" , ", ""},
+	}
+	for _, tt := range tests {
+		chain, err := newSourceChain(tt.order)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("newSourceChain(%q) = %s, want an error", tt.order, chain.Name())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newSourceChain(%q): %v", tt.order, err)
+			continue
+		}
+		if got := chain.Name(); got != tt.want {
+			t.Errorf("newSourceChain(%q) = %s, want %s", tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestGeminiCacheSource(t *testing.T) {
+	newTestServer(t, kesariya)
+	batches := [][]Song{
+		{{Title: "Kesariya", Artist: "Arijit Singh"}, {Title: "Chaleya", Artist: "Arijit Singh"}},
+		{{Title: "Hukum", Artist: "Anirudh Ravichander"}, {Title: "Arabic Kuthu", Artist: "Anirudh Ravichander"}},
+	}
+	fetches := 0
+	fetch := func(q songQuery) ([]Song, error) {
+		if fetches == len(batches) {
+			return nil, errors.New("no more batches")
+		}
+		fetches++
+		return batches[fetches-1], nil
+	}
+	src := &geminiCacheSource{fetch: fetch}
+	q := songQuery{Lang: "Hindi"}
+
+	if _, err := src.Next(context.Background(), q); !errors.Is(err, errSourceUnavailable) {
+		t.Fatalf("without an API key Next = %v, want errSourceUnavailable", err)
+	}
+	t.Setenv("GEMINI_API_KEY", "test")
+
+	// the first batch is played in order, then both videos are used up and
+	// the next batch is fetched
+	for i, want := range []string{"Kesariya", "Chaleya", "Hukum"} {
+		c, err := src.Next(context.Background(), q)
+		if err != nil {
+			t.Fatalf("round %d: %v", i+1, err)
+		}
+		if c.Title != want || c.YouTube == "" {
+			t.Errorf("round %d = %q (%s), want %q", i+1, c.Title, c.YouTube, want)
+		}
+	}
+	if fetches != 2 {
+		t.Errorf("fetched %d batches, want 2", fetches)
+	}
+
+	// after a restart the stored list is used instead of fetching again
+	src = &geminiCacheSource{fetch: fetch}
+	c, err := src.Next(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Title != "Arabic Kuthu" || fetches != 2 {
+		t.Errorf("after restart got %q with %d fetches, want Arabic Kuthu from the store", c.Title, fetches)
+	}
+}
+
+func TestSerpAPISource(t *testing.T) {
+	newTestServer(t, kesariya)
+	var gotQuery, gotKey string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/search.json":
+			gotQuery, gotKey = r.URL.Query().Get("q"), r.URL.Query().Get("api_key")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"organic_results": []map[string]string{
+					{"title": "Kesariya lyrics", "link": "https://example.com/kesariya"},
+					{"title": "Naatu Naatu Mashup", "link": "https://www.youtube.com/watch?v=fake0000002"},
+				},
+				"video_results": []map[string]string{
+					{"title": "Kesariya - Brahmastra", "link": "https://www.youtube.com/watch?v=fake0000001"},
+				},
+			})
+		case "/oembed":
+			json.NewEncoder(w).Encode(map[string]string{"title": "Kesariya", "author_name": "Arijit Singh"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+	src := newSerpAPISource()
+	src.baseURL, src.oembedURL, src.client = api.URL, api.URL+"/oembed", api.Client()
+	src.query = func(q songQuery) string { return "popular " + q.Lang + " song" }
+
+	t.Setenv("SERPAPI_API_KEY", "")
+	if _, err := src.Next(context.Background(), songQuery{Lang: "hindi"}); !errors.Is(err, errSourceUnavailable) {
+		t.Fatalf("without an API key Next = %v, want errSourceUnavailable", err)
+	}
+	t.Setenv("SERPAPI_API_KEY", "test-key")
+
+	// only the YouTube link without banned keywords is a candidate
+	c, err := src.Next(context.Background(), songQuery{Lang: "hindi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Title != "Kesariya" || c.Artist != "Arijit Singh" || c.YouTube != "https://www.youtube.com/watch?v=fake0000001" {
+		t.Errorf("Next = %+v, want Kesariya by Arijit Singh from oEmbed", c)
+	}
+	if gotQuery != "popular hindi song" || gotKey != "test-key" {
+		t.Errorf("searched q=%q api_key=%q", gotQuery, gotKey)
+	}
+	if _, err := src.Next(context.Background(), songQuery{Lang: "hindi"}); err == nil {
+		t.Error("the same video was returned twice")
+	}
+}
+
+func TestYTSearchSource(t *testing.T) {
+	newTestServer(t, kesariya)
+	f := &fakeMedia{}
+	f.Add(VideoInfo{ID: "fake0000001", Title: "Kesariya", Uploader: "Arijit Singh", Duration: 180})
+	f.Add(VideoInfo{ID: "fake0000002", Title: "Arijit Singh Best Of", Uploader: "Jukebox", Duration: 200})
+	f.Add(VideoInfo{ID: "fake0000003", Title: "Kesariya Teaser", Uploader: "Sony Music", Duration: 15})
+	f.Add(VideoInfo{ID: "fake0000004", Title: "Kesariya 1 Hour Loop", Uploader: "Loops", Duration: 3600})
+	media = f
+	src := &ytSearchSource{query: func(q songQuery) string { return "kesariya" }}
+
+	c, err := src.Next(context.Background(), songQuery{Lang: "hindi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Title != "Kesariya" || c.Artist != "Arijit Singh" || c.Duration != 180 {
+		t.Errorf("Next = %+v, want the only single song result", c)
+	}
+	if _, err := src.Next(context.Background(), songQuery{Lang: "hindi"}); err == nil {
+		t.Error("the same video was returned twice")
+	}
+}
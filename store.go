@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store persists rounds, used video IDs, cached Gemini song lists, aliases,
+// player sessions, matches and playlists so the server survives restarts without
+// repeating videos.
+type Store interface {
+	PutRound(r Round) error
+	GetRound(id string) (Round, bool)
+	// UpdateRound applies fn to the stored round and persists the result.
+	UpdateRound(id string, fn func(*Round)) (Round, error)
+	// Rounds returns every stored round, oldest first.
+	Rounds() []Round
+	// SessionRounds returns the rounds played in a session, oldest first.
+	SessionRounds(sessionID string) []Round
+
+	MarkUsed(videoID string) error
+	IsUsed(videoID string) bool
+
+	PutSongList(key string, songs []Song) error
+	SongList(key string) ([]Song, bool)
+
+	PutSession(s Session) error
+	GetSession(id string) (Session, bool)
+
+	PutMatch(m Match) error
+	GetMatch(id string) (Match, bool)
+	// UpdateMatch applies fn to the stored match and persists the result.
+	UpdateMatch(id string, fn func(*Match)) (Match, error)
+
+	PutPlaylist(p Playlist) error
+	GetPlaylist(id string) (Playlist, bool)
+	// Playlists returns every stored playlist, oldest first.
+	Playlists() []Playlist
+
+	// PruneRounds deletes expired rounds and matches created before cutoff
+	// and returns how many rounds it removed.
+	PruneRounds(cutoff time.Time) int
+
+	// PutAliases replaces the curated aliases of a title, keyed by aliasKey.
+	PutAliases(key string, aliases []string) error
+	Aliases(key string) []string
+
+	Close() error
+}
+
+var (
+	errRoundNotFound = errors.New("round not found")
+	errMatchNotFound = errors.New("match not found")
+)
+
+// store is the process wide Store. run replaces it with a file backed one.
+var store Store = newMemoryStore()
+
+// defaultStorePath is used when STORE_PATH is not set.
+const defaultStorePath = "songs_ai_agent.json"
+
+// openStore opens the store configured by STORE_PATH. The special value
+// "memory" keeps everything in memory.
+func openStore(path string) (Store, error) {
+	if path == "" {
+		path = defaultStorePath
+	}
+	if path == "memory" {
+		return newMemoryStore(), nil
+	}
+	return newFileStore(path)
+}
+
+// storeData is the on-disk layout of fileStore.
+type storeData struct {
+	Rounds    map[string]*Round    `json:"rounds"`
+	Used      map[string]bool      `json:"used_videos"`
+	SongLists map[string][]Song    `json:"song_lists"`
+	Aliases   map[string][]string  `json:"aliases"`
+	Sessions  map[string]*Session  `json:"sessions"`
+	Matches   map[string]*Match    `json:"matches"`
+	Playlists map[string]*Playlist `json:"playlists"`
+}
+
+// fileStore keeps everything in memory and rewrites a single JSON file at
+// most once per storeFlushDelay, however many changes were made in between.
+// Only the snapshot is taken under mu; the file is written and synced under
+// writeMu so requests never wait for the disk. Set ROUND_HISTORY_DAYS to have the janitor prune old rounds when the file
+// grows too large. An empty path disables persistence.
+type fileStore struct {
+	path string
+
+	mu    sync.Mutex
+	data  storeData
+	dirty bool        // changed since the last snapshot was taken
+	timer *time.Timer // pending flush, nil when there is none
+
+	writeMu sync.Mutex // serializes flushes
+
+	// bySession holds the IDs of each session's rounds so summaries and
+	// scores don't scan every round. It is rebuilt on load.
+	bySession map[string]map[string]bool
+}
+
+// storeFlushDelay is how long changes may sit in memory before they are
+// written. A crash loses at most this much.
+const storeFlushDelay = time.Second
+
+func newMemoryStore() *fileStore {
+	return &fileStore{bySession: map[string]map[string]bool{}, data: storeData{
+		Rounds:    map[string]*Round{},
+		Used:      map[string]bool{},
+		SongLists: map[string][]Song{},
+		Aliases:   map[string][]string{},
+		Sessions:  map[string]*Session{},
+		Matches:   map[string]*Match{},
+		Playlists: map[string]*Playlist{},
+	}}
+}
+
+func newFileStore(path string) (*fileStore, error) {
+	s := newMemoryStore()
+	s.path = path
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.data); err != nil {
+		return nil, fmt.Errorf("read store %s: %v", path, err)
+	}
+	if s.data.Rounds == nil {
+		s.data.Rounds = map[string]*Round{}
+	}
+	if s.data.Used == nil {
+		s.data.Used = map[string]bool{}
+	}
+	if s.data.SongLists == nil {
+		s.data.SongLists = map[string][]Song{}
+	}
+	if s.data.Aliases == nil {
+		s.data.Aliases = map[string][]string{}
+	}
+	if s.data.Sessions == nil {
+		s.data.Sessions = map[string]*Session{}
+	}
+	if s.data.Matches == nil {
+		s.data.Matches = map[string]*Match{}
+	}
+	if s.data.Playlists == nil {
+		s.data.Playlists = map[string]*Playlist{}
+	}
+	// downloads do not survive a restart. Expired and finished rounds are
+	// not Ready either but were never interrupted.
+	for _, r := range s.data.Rounds {
+		s.indexLocked(r)
+		if !r.Ready && r.Error == "" && !r.Expired && !r.Solved && !r.Revealed {
+			r.Error = "interrupted by server restart"
+			r.Stage = stageFailed
+		}
+	}
+	log.Printf("loaded store %s: %d rounds, %d used videos, %d song lists", path, len(s.data.Rounds), len(s.data.Used), len(s.data.SongLists))
+	return s, nil
+}
+
+// saveLocked schedules a flush of the store. s.mu must be held.
+func (s *fileStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	s.dirty = true
+	if s.timer == nil {
+		s.timer = time.AfterFunc(storeFlushDelay, func() {
+			s.mu.Lock()
+			s.timer = nil
+			s.mu.Unlock()
+			if err := s.flush(); err != nil {
+				log.Printf("save store %s: %v", s.path, err)
+			}
+		})
+	}
+	return nil
+}
+
+// flush writes the store atomically if it has unsaved changes. The file is
+// synced before it replaces the old one. Changes made while the file is
+// being written mark the store dirty again and schedule another flush; a
+// failed write does the same so it is retried.
+func (s *fileStore) flush() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	if s.path == "" || !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(&s.data)
+	if err == nil {
+		s.dirty = false
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileSynced(s.path, b); err != nil {
+		s.mu.Lock()
+		s.saveLocked()
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// writeFileSynced replaces path with b through a synced temporary file, so
+// a crash leaves either the old or the new contents.
+func writeFileSynced(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *fileStore) PutRound(r Round) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.data.Rounds[r.ID]; ok {
+		s.unindexLocked(old)
+	}
+	s.data.Rounds[r.ID] = &r
+	s.indexLocked(&r)
+	return s.saveLocked()
+}
+
+// indexLocked adds r to the session index. s.mu must be held.
+func (s *fileStore) indexLocked(r *Round) {
+	if r.SessionID == "" {
+		return
+	}
+	ids := s.bySession[r.SessionID]
+	if ids == nil {
+		ids = map[string]bool{}
+		s.bySession[r.SessionID] = ids
+	}
+	ids[r.ID] = true
+}
+
+// unindexLocked removes r from the session index. s.mu must be held.
+func (s *fileStore) unindexLocked(r *Round) {
+	if ids := s.bySession[r.SessionID]; ids != nil {
+		delete(ids, r.ID)
+		if len(ids) == 0 {
+			delete(s.bySession, r.SessionID)
+		}
+	}
+}
+
+func (s *fileStore) GetRound(id string) (Round, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.data.Rounds[id]
+	if !ok {
+		return Round{}, false
+	}
+	return *r, true
+}
+
+func (s *fileStore) UpdateRound(id string, fn func(*Round)) (Round, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.data.Rounds[id]
+	if !ok {
+		return Round{}, errRoundNotFound
+	}
+	s.unindexLocked(r)
+	fn(r)
+	s.indexLocked(r)
+	return *r, s.saveLocked()
+}
+
+func (s *fileStore) Rounds() []Round {
+	s.mu.Lock()
+	out := make([]Round, 0, len(s.data.Rounds))
+	for _, r := range s.data.Rounds {
+		out = append(out, *r)
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+func (s *fileStore) SessionRounds(sessionID string) []Round {
+	s.mu.Lock()
+	ids := s.bySession[sessionID]
+	out := make([]Round, 0, len(ids))
+	for id := range ids {
+		out = append(out, *s.data.Rounds[id])
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+func (s *fileStore) MarkUsed(videoID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Used[videoID] = true
+	return s.saveLocked()
+}
+
+func (s *fileStore) IsUsed(videoID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Used[videoID]
+}
+
+func (s *fileStore) PutSongList(key string, songs []Song) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.SongLists[key] = append([]Song(nil), songs...)
+	return s.saveLocked()
+}
+
+func (s *fileStore) SongList(key string) ([]Song, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	songs, ok := s.data.SongLists[key]
+	return append([]Song(nil), songs...), ok
+}
+
+func (s *fileStore) PutSession(sess Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Sessions[sess.ID] = &sess
+	return s.saveLocked()
+}
+
+func (s *fileStore) GetSession(id string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.data.Sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+	return *sess, true
+}
+
+func (s *fileStore) PutMatch(m Match) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.RoundIDs = append([]string(nil), m.RoundIDs...)
+	s.data.Matches[m.ID] = &m
+	return s.saveLocked()
+}
+
+func (s *fileStore) GetMatch(id string) (Match, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.data.Matches[id]
+	if !ok {
+		return Match{}, false
+	}
+	out := *m
+	out.RoundIDs = append([]string(nil), m.RoundIDs...)
+	return out, true
+}
+
+func (s *fileStore) UpdateMatch(id string, fn func(*Match)) (Match, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.data.Matches[id]
+	if !ok {
+		return Match{}, errMatchNotFound
+	}
+	fn(m)
+	out := *m
+	out.RoundIDs = append([]string(nil), m.RoundIDs...)
+	return out, s.saveLocked()
+}
+
+func (s *fileStore) PutPlaylist(p Playlist) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.Songs = append([]Song(nil), p.Songs...)
+	s.data.Playlists[p.ID] = &p
+	return s.saveLocked()
+}
+
+func (s *fileStore) GetPlaylist(id string) (Playlist, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.data.Playlists[id]
+	if !ok {
+		return Playlist{}, false
+	}
+	out := *p
+	out.Songs = append([]Song(nil), p.Songs...)
+	return out, true
+}
+
+func (s *fileStore) Playlists() []Playlist {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Playlist, 0, len(s.data.Playlists))
+	for _, p := range s.data.Playlists {
+		cp := *p
+		cp.Songs = append([]Song(nil), p.Songs...)
+		out = append(out, cp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+func (s *fileStore) PutAliases(key string, aliases []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(aliases) == 0 {
+		delete(s.data.Aliases, key)
+	} else {
+		s.data.Aliases[key] = append([]string(nil), aliases...)
+	}
+	return s.saveLocked()
+}
+
+func (s *fileStore) Aliases(key string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.data.Aliases[key]...)
+}
+
+func (s *fileStore) PruneRounds(cutoff time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, r := range s.data.Rounds {
+		if r.Expired && r.CreatedAt.Before(cutoff) {
+			s.unindexLocked(r)
+			delete(s.data.Rounds, id)
+			n++
+		}
+	}
+	for id, m := range s.data.Matches {
+		if m.CreatedAt.Before(cutoff) {
+			delete(s.data.Matches, id)
+		}
+	}
+	if n > 0 {
+		s.saveLocked()
+	}
+	return n
+}
+
+// Close writes any pending changes.
+func (s *fileStore) Close() error {
+	s.mu.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.mu.Unlock()
+	return s.flush()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileStoreWritesOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		s.MarkUsed(randomID(11))
+	}
+	if err := s.PutRound(Round{ID: "r1", Lang: "hindi", Title: "Kesariya", Ready: true, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	if ri, ok := s2.GetRound("r1"); !ok || ri.Title != "Kesariya" {
+		t.Errorf("round after reopening = %+v, %v", ri, ok)
+	}
+	if n := len(s2.data.Used); n != 100 {
+		t.Errorf("%d used videos after reopening, want 100", n)
+	}
+}
+
+func TestFileStoreUsableDuringFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.PutRound(Round{ID: "r1", CreatedAt: time.Now()})
+
+	// a flush stuck on the disk must not hold up the store
+	s.writeMu.Lock()
+	done := make(chan struct{})
+	go func() {
+		s.PutRound(Round{ID: "r2", CreatedAt: time.Now()})
+		s.GetRound("r1")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("store blocked while a flush was writing")
+	}
+	s.writeMu.Unlock()
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s2, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	for _, id := range []string{"r1", "r2"} {
+		if _, ok := s2.GetRound(id); !ok {
+			t.Errorf("round %s lost", id)
+		}
+	}
+}
+
+func TestFileStoreRestartFailsOnlyDownloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.PutRound(Round{ID: "downloading", Stage: stageDownloading, CreatedAt: now})
+	s.PutRound(Round{ID: "expired", Stage: stageReady, Solved: true, Expired: true, CreatedAt: now})
+	s.PutRound(Round{ID: "revealed", Stage: stageReady, Revealed: true, CreatedAt: now})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s2, err := newFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.Close()
+	for id, failed := range map[string]bool{"downloading": true, "expired": false, "revealed": false} {
+		r, _ := s2.GetRound(id)
+		if got := r.Stage == stageFailed && r.Error != ""; got != failed {
+			t.Errorf("round %s after restart: stage %q, error %q, want failed %v", id, r.Stage, r.Error, failed)
+		}
+	}
+}
+
+func TestPruneRounds(t *testing.T) {
+	s := newMemoryStore()
+	now := time.Now()
+	old := now.Add(-100 * 24 * time.Hour)
+	s.PutRound(Round{ID: "old-expired", Expired: true, CreatedAt: old})
+	s.PutRound(Round{ID: "old-live", CreatedAt: old})
+	s.PutRound(Round{ID: "new-expired", Expired: true, CreatedAt: now})
+	s.PutMatch(Match{ID: "old-match", CreatedAt: old})
+	s.PutMatch(Match{ID: "new-match", CreatedAt: now})
+
+	if n := s.PruneRounds(now.Add(-90 * 24 * time.Hour)); n != 1 {
+		t.Errorf("pruned %d rounds, want 1", n)
+	}
+	for id, want := range map[string]bool{"old-expired": false, "old-live": true, "new-expired": true} {
+		if _, ok := s.GetRound(id); ok != want {
+			t.Errorf("round %s kept = %v, want %v", id, ok, want)
+		}
+	}
+	if _, ok := s.GetMatch("old-match"); ok {
+		t.Error("old match was kept")
+	}
+	if _, ok := s.GetMatch("new-match"); !ok {
+		t.Error("new match was pruned")
+	}
+}
+
+func TestSessionRounds(t *testing.T) {
+	s := newMemoryStore()
+	now := time.Now()
+	s.PutRound(Round{ID: "r2", SessionID: "alice", Points: 200, CreatedAt: now})
+	s.PutRound(Round{ID: "r1", SessionID: "alice", Points: 100, CreatedAt: now.Add(-time.Minute)})
+	s.PutRound(Round{ID: "r3", SessionID: "bob", CreatedAt: now.Add(time.Minute)})
+	s.PutRound(Round{ID: "r4", CreatedAt: now})
+	// ownership can change after a round is stored
+	s.UpdateRound("r3", func(r *Round) { r.SessionID = "alice" })
+
+	var ids []string
+	for _, r := range s.SessionRounds("alice") {
+		ids = append(ids, r.ID)
+	}
+	if got := strings.Join(ids, ","); got != "r1,r2,r3" {
+		t.Errorf("alice's rounds = %s, want r1,r2,r3 oldest first", got)
+	}
+	if n := len(s.SessionRounds("bob")); n != 0 {
+		t.Errorf("bob has %d rounds after the move, want 0", n)
+	}
+	if n := len(s.SessionRounds("")); n != 0 {
+		t.Errorf("%d rounds without a session are indexed", n)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Devanagari and Tamil are transliterated to a loose Latin form so a guess
+// typed on a romanized keyboard can be compared with a native script title
+// and vice versa. The output is not meant to be a faithful scheme such as
+// ISO 15919, only close enough for foldRomanization and edit distance.
+
+// indicScript describes how to romanize one abugida.
+type indicScript struct {
+	vowels     map[rune]string // independent vowels
+	signs      map[rune]string // dependent vowel signs (matras)
+	consonants map[rune]string
+	virama     rune
+	nukta      rune
+	// dropFinalSchwa removes the inherent "a" at the end of a word, which
+	// Hindi does not pronounce ("दिल" is "dil", not "dila").
+	dropFinalSchwa bool
+	other          map[rune]string // anusvara, visarga, digits...
+}
+
+var devanagari = indicScript{
+	vowels: map[rune]string{
+		'अ': "a", 'आ': "aa", 'इ': "i", 'ई': "ii", 'उ': "u", 'ऊ': "uu", 'ऋ': "ri",
+		'ए': "e", 'ऐ': "ai", 'ओ': "o", 'औ': "au", 'ऑ': "o", 'ऍ': "e",
+	},
+	signs: map[rune]string{
+		'ा': "aa", 'ि': "i", 'ी': "ii", 'ु': "u", 'ू': "uu", 'ृ': "ri",
+		'े': "e", 'ै': "ai", 'ो': "o", 'ौ': "au", 'ॉ': "o", 'ॅ': "e",
+	},
+	consonants: map[rune]string{
+		'क': "k", 'ख': "kh", 'ग': "g", 'घ': "gh", 'ङ': "n",
+		'च': "ch", 'छ': "chh", 'ज': "j", 'झ': "jh", 'ञ': "n",
+		'ट': "t", 'ठ': "th", 'ड': "d", 'ढ': "dh", 'ण': "n",
+		'त': "t", 'थ': "th", 'द': "d", 'ध': "dh", 'न': "n",
+		'प': "p", 'फ': "ph", 'ब': "b", 'भ': "bh", 'म': "m",
+		'य': "y", 'र': "r", 'ल': "l", 'ळ': "l", 'व': "v",
+		'श': "sh", 'ष': "sh", 'स': "s", 'ह': "h",
+	},
+	virama:         '्',
+	nukta:          '़',
+	dropFinalSchwa: true,
+	other: map[rune]string{
+		'ं': "n", 'ँ': "n", 'ः': "h", 'ॐ': "om", '।': " ", '॥': " ",
+		'०': "0", '१': "1", '२': "2", '३': "3", '४': "4", '५': "5", '६': "6", '७': "7", '८': "8", '९': "9",
+	},
+}
+
+var tamil = indicScript{
+	vowels: map[rune]string{
+		'அ': "a", 'ஆ': "aa", 'இ': "i", 'ஈ': "ii", 'உ': "u", 'ஊ': "uu",
+		'எ': "e", 'ஏ': "ee", 'ஐ': "ai", 'ஒ': "o", 'ஓ': "oo", 'ஔ': "au",
+	},
+	signs: map[rune]string{
+		'ா': "aa", 'ி': "i", 'ீ': "ii", 'ு': "u", 'ூ': "uu",
+		'ெ': "e", 'ே': "ee", 'ை': "ai", 'ொ': "o", 'ோ': "oo", 'ௌ': "au",
+	},
+	consonants: map[rune]string{
+		'க': "k", 'ங': "ng", 'ச': "ch", 'ஞ': "nj", 'ட': "t", 'ண': "n",
+		'த': "th", 'ந': "n", 'ப': "p", 'ம': "m", 'ய': "y", 'ர': "r",
+		'ல': "l", 'வ': "v", 'ழ': "zh", 'ள': "l", 'ற': "r", 'ன': "n",
+		'ஜ': "j", 'ஷ': "sh", 'ஸ': "s", 'ஹ': "h",
+	},
+	virama: '்',
+	other: map[rune]string{
+		'ஃ': "h",
+		'௦': "0", '௧': "1", '௨': "2", '௩': "3", '௪': "4", '௫': "5", '௬': "6", '௭': "7", '௮': "8", '௯': "9",
+	},
+}
+
+// nuktaForms maps a consonant's romanization to its nukta variant (क़ -> q).
+var nuktaForms = map[string]string{"k": "q", "kh": "kh", "g": "g", "j": "z", "d": "r", "dh": "rh", "ph": "f", "y": "y"}
+
+func scriptFor(r rune) *indicScript {
+	switch {
+	case unicode.Is(unicode.Devanagari, r):
+		return &devanagari
+	case unicode.Is(unicode.Tamil, r):
+		return &tamil
+	}
+	return nil
+}
+
+// transliterate romanizes Devanagari and Tamil runs in s and leaves
+// everything else untouched.
+func transliterate(s string) string {
+	runes := []rune(norm.NFC.String(s))
+	var b strings.Builder
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		sc := scriptFor(r)
+		if sc == nil {
+			b.WriteRune(r)
+			continue
+		}
+		if v, ok := sc.vowels[r]; ok {
+			b.WriteString(v)
+			continue
+		}
+		if o, ok := sc.other[r]; ok {
+			b.WriteString(o)
+			continue
+		}
+		c, ok := sc.consonants[r]
+		if !ok {
+			// stray sign or unknown letter
+			if v, ok := sc.signs[r]; ok {
+				b.WriteString(v)
+			}
+			continue
+		}
+		if i+1 < len(runes) && runes[i+1] == sc.nukta && sc.nukta != 0 {
+			if n, ok := nuktaForms[c]; ok {
+				c = n
+			}
+			i++
+		}
+		b.WriteString(c)
+		// the inherent vowel is replaced by a sign, silenced by the
+		// virama, or dropped at the end of a Hindi word
+		if i+1 < len(runes) {
+			next := runes[i+1]
+			if v, ok := sc.signs[next]; ok {
+				b.WriteString(v)
+				i++
+				continue
+			}
+			if next == sc.virama {
+				i++
+				continue
+			}
+			if sc.dropFinalSchwa && !keepsSchwa(sc, next) {
+				continue
+			}
+		} else if sc.dropFinalSchwa {
+			continue
+		}
+		b.WriteString("a")
+	}
+	return b.String()
+}
+
+// keepsSchwa reports whether the inherent vowel before r is pronounced,
+// i.e. r continues the word.
+func keepsSchwa(sc *indicScript, r rune) bool {
+	if _, ok := sc.consonants[r]; ok {
+		return true
+	}
+	if _, ok := sc.vowels[r]; ok {
+		return true
+	}
+	return r == 'ं' || r == 'ँ' || r == 'ः'
+}
+
+// romanFolds collapse spellings that players use interchangeably when
+// romanizing Hindi and Tamil ("Tamizh"/"Tamil", "Dilwale"/"Dilvale",
+// "Kuthu"/"Kutu"). Order matters: longer patterns first.
+var romanFolds = strings.NewReplacer(
+	"zh", "l",
+	"chh", "ch",
+	"aa", "a", "ee", "i", "ii", "i", "oo", "u", "uu", "u",
+	"kh", "k", "gh", "g", "jh", "j", "th", "t", "dh", "d", "ph", "f", "bh", "b", "sh", "s",
+	"w", "v", "q", "k", "z", "j",
+)
+
+// hasIndicScript reports whether s contains Devanagari or Tamil letters.
+func hasIndicScript(s string) bool {
+	for _, r := range s {
+		if scriptFor(r) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isIndicLang reports whether lang is an Indian language whose titles are
+// usually romanized, see indianLangs.
+func isIndicLang(lang string) bool {
+	lang = cacheKey(lang)
+	for _, l := range indianLangs {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// foldRomanization maps romanization variants to one canonical spelling and
+// collapses doubled letters. Both sides of a comparison must be folded.
+func foldRomanization(s string) string {
+	s = romanFolds.Replace(s)
+	var b strings.Builder
+	var prev rune
+	for _, r := range s {
+		if r == prev && unicode.IsLetter(r) {
+			continue
+		}
+		b.WriteRune(r)
+		prev = r
+	}
+	return b.String()
+}